@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karlseguin/ccache"
+	"golang.org/x/time/rate"
+)
+
+var (
+	abuse       *abuseTracker
+	denylist    = &denyList{clients: map[string]bool{}, codeHashes: map[string]bool{}}
+	errBanned   = errors.New("Too many abusive requests from this client, please try again later")
+	errDenied   = errors.New("This client has been blocked by an administrator")
+	errCodeDeny = errors.New("This code has been blocked by an administrator")
+)
+
+// abuseKind is a type of request outcome that counts against a client.
+type abuseKind string
+
+const (
+	abuseTimeout     abuseKind = "timeout"
+	abuseTooLarge    abuseKind = "too_large"
+	abuseRateLimited abuseKind = "rate_limited"
+	// abuseWorkspaceLimit is a workspace refused for going over the client's
+	// own limit
+	abuseWorkspaceLimit abuseKind = "workspace_limit"
+)
+
+// clientEvents holds the times at which a single client triggered each kind
+// of abusive outcome, oldest first.
+type clientEvents struct {
+	sync.Mutex
+	times map[abuseKind][]time.Time
+}
+
+// abuseTracker counts abusive outcomes per client over a sliding window and
+// temporarily bans clients that cross the configured thresholds. It also
+// holds each client's own rate limiter. The counters, limiters and bans live
+// in LRU caches so a flood of distinct clients can't grow memory without
+// bound.
+type abuseTracker struct {
+	mu       sync.Mutex
+	events   *ccache.Cache
+	limiters *ccache.Cache
+	bans     *ccache.Cache
+}
+
+func newAbuseTracker() *abuseTracker {
+	return &abuseTracker{
+		events:   ccache.New(ccache.Configure().MaxSize(config.CacheSize)),
+		limiters: ccache.New(ccache.Configure().MaxSize(config.CacheSize)),
+		bans:     ccache.New(ccache.Configure().MaxSize(config.CacheSize)),
+	}
+}
+
+// allow reports whether client is within its own rate limit, recording an
+// abusive outcome when it isn't. Unlike the global limit, which innocent
+// clients hit when the server is busy, only the client can exceed its own.
+func (t *abuseTracker) allow(client string) bool {
+	if config.ClientRateLimit <= 0 {
+		return true
+	}
+	t.mu.Lock()
+	item, _ := t.limiters.Fetch(client, time.Hour, func() (interface{}, error) {
+		return rate.NewLimiter(config.ClientRateLimit, config.ClientRateLimitBurst), nil
+	})
+	t.mu.Unlock()
+	item.Extend(time.Hour)
+	if item.Value().(*rate.Limiter).Allow() {
+		return true
+	}
+	t.record(client, abuseRateLimited)
+	return false
+}
+
+// threshold returns the number of events of the given kind that a client may
+// trigger within the window before being banned. Zero disables the check.
+func (kind abuseKind) threshold() int {
+	switch kind {
+	case abuseTimeout:
+		return config.AbuseMaxTimeouts
+	case abuseTooLarge:
+		return config.AbuseMaxTooLarge
+	case abuseRateLimited:
+		return config.AbuseMaxRateLimited
+	case abuseWorkspaceLimit:
+		return config.AbuseMaxWorkspaceLimited
+	}
+	return 0
+}
+
+// record notes that client triggered an abusive outcome, and bans the client
+// if this pushes it past the threshold for that kind.
+func (t *abuseTracker) record(client string, kind abuseKind) {
+	p8sAbuseEvents.WithLabelValues(string(kind)).Inc()
+
+	t.mu.Lock()
+	item, _ := t.events.Fetch(client, config.AbuseWindow, func() (interface{}, error) {
+		return &clientEvents{times: map[abuseKind][]time.Time{}}, nil
+	})
+	t.mu.Unlock()
+	item.Extend(config.AbuseWindow)
+	events := item.Value().(*clientEvents)
+
+	now := time.Now()
+	cutoff := now.Add(-config.AbuseWindow)
+
+	events.Lock()
+	times := events.times[kind]
+	for len(times) > 0 && times[0].Before(cutoff) {
+		times = times[1:]
+	}
+	times = append(times, now)
+	events.times[kind] = times
+	count := len(times)
+	events.Unlock()
+
+	if limit := kind.threshold(); limit > 0 && count > limit {
+		t.ban(client, kind)
+	}
+}
+
+// ban blocks client for the configured ban duration.
+func (t *abuseTracker) ban(client string, kind abuseKind) {
+	if item := t.bans.Get(client); item != nil && !item.Expired() {
+		return
+	}
+	t.bans.Set(client, kind, config.AbuseBanDuration)
+	t.events.Delete(client)
+	p8sClientBans.WithLabelValues(string(kind)).Inc()
+	log.Printf("Banned client %s for %v: too many %s events within %v",
+		client, config.AbuseBanDuration, kind, config.AbuseWindow)
+}
+
+// banned reports whether client is currently serving a temporary ban.
+func (t *abuseTracker) banned(client string) bool {
+	item := t.bans.Get(client)
+	return item != nil && !item.Expired()
+}
+
+// lift removes any temporary ban on client, returning whether there was one.
+func (t *abuseTracker) lift(client string) bool {
+	t.events.Delete(client)
+	return t.bans.Delete(client)
+}
+
+// denyList is the operator-maintained set of client identities and code hash
+// patterns that are refused outright. Code hash patterns use path.Match
+// syntax against the hex SHA-256 of the submitted code.
+type denyList struct {
+	sync.RWMutex
+	clients    map[string]bool
+	codeHashes map[string]bool
+}
+
+// DenyListEntries is the JSON representation of the deny list used by the
+// admin endpoint.
+type DenyListEntries struct {
+	Clients    []string `json:"clients"`
+	CodeHashes []string `json:"codeHashes"`
+}
+
+func (d *denyList) entries() DenyListEntries {
+	d.RLock()
+	defer d.RUnlock()
+	return DenyListEntries{
+		Clients:    sortedKeys(d.clients),
+		CodeHashes: sortedKeys(d.codeHashes),
+	}
+}
+
+func (d *denyList) add(e DenyListEntries) error {
+	for _, pattern := range e.CodeHashes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	for _, client := range e.Clients {
+		d.clients[client] = true
+	}
+	for _, pattern := range e.CodeHashes {
+		d.codeHashes[strings.ToLower(pattern)] = true
+	}
+	return nil
+}
+
+func (d *denyList) remove(e DenyListEntries) {
+	d.Lock()
+	defer d.Unlock()
+	for _, client := range e.Clients {
+		delete(d.clients, client)
+	}
+	for _, pattern := range e.CodeHashes {
+		delete(d.codeHashes, strings.ToLower(pattern))
+	}
+}
+
+func (d *denyList) clientDenied(client string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	return d.clients[client]
+}
+
+// codeDenied returns whether hash matches any of the denied patterns.
+func (d *denyList) codeDenied(hash string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	for pattern := range d.codeHashes {
+		if ok, _ := path.Match(pattern, hash); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// codeHash returns the hex SHA-256 of a piece of jsonnet code, which is how
+// code is identified in logs and in the deny list.
+func codeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientID identifies the client that sent r. Behind config.TrustedProxies
+// proxies, each of which appends the address it was reached from to
+// X-Forwarded-For, that's the hop the outermost proxy added; hops to the
+// left of it come from the client and can't be trusted. Otherwise it's the
+// remote IP.
+func clientID(r *http.Request) string {
+	if n := config.TrustedProxies; n > 0 {
+		hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+		if len(hops) >= n {
+			if hop := strings.TrimSpace(hops[len(hops)-n]); hop != "" {
+				return hop
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// denyListHandler lets operators inspect and edit the deny list. GET returns
+// the current entries, POST adds the entries in the body and DELETE removes
+// them. A DELETE also lifts any temporary ban on the listed clients.
+func denyListHandler(w http.ResponseWriter, r *http.Request) {
+	var e DenyListEntries
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if err := denylist.add(e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Deny list: added clients %v, code hashes %v", e.Clients, e.CodeHashes)
+	case http.MethodDelete:
+		denylist.remove(e)
+		for _, client := range e.Clients {
+			if abuse.lift(client) {
+				log.Printf("Lifted temporary ban on client %s", client)
+			}
+		}
+		log.Printf("Deny list: removed clients %v, code hashes %v", e.Clients, e.CodeHashes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(denylist.entries())
+}
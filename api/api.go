@@ -1,30 +1,174 @@
 package api
 
+// InitRequest describes a new ksonnet app and its first environment. Only
+// AppName and Server are required.
 type InitRequest struct {
-	AppName string `json:"appName"`
+	AppName     string `json:"appName"`
+	Environment string `json:"environment"`
+	Server      string `json:"server"`
+	Namespace   string `json:"namespace"`
+	K8sVersion  string `json:"k8sVersion"`
 }
 
+// InitResponse is the tree of a new ksonnet app. Components and Environments
+// hold the contents of the directories of the same names, with files mapped
+// to their contents and subdirectories to maps of their own.
 type InitResponse struct {
 	AppName      string                 `json:"appName"`
+	AppYAML      string                 `json:"appYaml"`
 	Components   map[string]interface{} `json:"components"`
 	Environments map[string]interface{} `json:"environments"`
 }
 
+// ShowRequest is an app to render, in the shape of an InitResponse, along
+// with the environment to render it for ("default" if empty), the Kubernetes
+// version whose library it imports ("v1.7.0" if empty) and the Format of the
+// response, "yaml" (the default) or "json".
 type ShowRequest struct {
 	AppName      string                 `json:"appName"`
 	Components   map[string]interface{} `json:"components"`
 	Environments map[string]interface{} `json:"environments"`
+	Environment  string                 `json:"environment"`
+	K8sVersion   string                 `json:"k8sVersion"`
+	Format       string                 `json:"format"`
 }
 
+// ShowResponse maps each component to its Kubernetes objects: a YAML stream
+// of them, or an array of them for the "json" format.
 type ShowResponse struct {
 	Components map[string]interface{} `json:"components"`
 }
 
+// GenerateRequest fills the prototype with the full or short Name with
+// Parameters. The component is named ComponentName, or else after the name
+// parameter.
 type GenerateRequest struct {
-	Name       string                 `json:"name"`
-	Parameters map[string]interface{} `json:"parameters"`
+	Name          string                 `json:"name"`
+	Parameters    map[string]interface{} `json:"parameters"`
+	ComponentName string                 `json:"componentName"`
 }
 
+// GenerateResponse maps the file name of the new component to its contents.
 type GenerateResponse struct {
 	Components map[string]interface{} `json:"components"`
 }
+
+// Environment is an environment of an app, as its spec.json describes it.
+type Environment struct {
+	Name      string `json:"name"`
+	Server    string `json:"server"`
+	Namespace string `json:"namespace"`
+}
+
+// EnvRequest changes the environments of an app, sent in the shape of an
+// InitResponse, like `ks env` does. Name is the environment to add, update
+// or remove. Adding one needs a Server, and the Namespace and K8sVersion
+// default as they do for InitRequest. Updating one changes the non-empty
+// fields of Server, Namespace and NewName, which renames it.
+type EnvRequest struct {
+	AppName      string                 `json:"appName"`
+	AppYAML      string                 `json:"appYaml"`
+	Components   map[string]interface{} `json:"components"`
+	Environments map[string]interface{} `json:"environments"`
+	Name         string                 `json:"name"`
+	Server       string                 `json:"server"`
+	Namespace    string                 `json:"namespace"`
+	K8sVersion   string                 `json:"k8sVersion"`
+	NewName      string                 `json:"newName"`
+}
+
+// EnvListResponse lists the environments of an app, sorted by name.
+type EnvListResponse struct {
+	Environments []Environment `json:"environments"`
+}
+
+// ParamRequest works on the component parameters of an app, sent in the
+// shape of an InitResponse, like `ks param` does. An empty Environment means
+// the global parameters in components/params.libsonnet. Value is the new
+// value of the Param of the Component, for setting it, and diffs compare
+// Environment with OtherEnvironment.
+type ParamRequest struct {
+	AppName          string                 `json:"appName"`
+	AppYAML          string                 `json:"appYaml"`
+	Components       map[string]interface{} `json:"components"`
+	Environments     map[string]interface{} `json:"environments"`
+	Environment      string                 `json:"environment"`
+	OtherEnvironment string                 `json:"otherEnvironment"`
+	Component        string                 `json:"component"`
+	Param            string                 `json:"param"`
+	Value            interface{}            `json:"value"`
+}
+
+// Param is the value of a parameter of a component.
+type Param struct {
+	Component string      `json:"component"`
+	Param     string      `json:"param"`
+	Value     interface{} `json:"value"`
+}
+
+// ParamListResponse lists the effective parameters of the components of an
+// app in an environment, sorted by component and parameter.
+type ParamListResponse struct {
+	Params []Param `json:"params"`
+}
+
+// ParamDiff is a parameter whose value differs between two environments.
+// Value and OtherValue are left out when the parameter isn't set in
+// Environment or OtherEnvironment respectively.
+type ParamDiff struct {
+	Component  string      `json:"component"`
+	Param      string      `json:"param"`
+	Value      interface{} `json:"value,omitempty"`
+	OtherValue interface{} `json:"otherValue,omitempty"`
+}
+
+// ParamDiffResponse lists the parameters that differ between two
+// environments, sorted by component and parameter.
+type ParamDiffResponse struct {
+	Diffs []ParamDiff `json:"diffs"`
+}
+
+// DiffRequest compares the objects an app, sent in the shape of an
+// InitResponse, renders for Environment with those it renders for
+// OtherEnvironment. K8sVersion is as for ShowRequest.
+type DiffRequest struct {
+	AppName          string                 `json:"appName"`
+	Components       map[string]interface{} `json:"components"`
+	Environments     map[string]interface{} `json:"environments"`
+	Environment      string                 `json:"environment"`
+	OtherEnvironment string                 `json:"otherEnvironment"`
+	K8sVersion       string                 `json:"k8sVersion"`
+}
+
+// FieldChange is a field of an object that differs between two
+// environments, located by a path like .spec.template.spec.containers[0].
+// Value and OtherValue are left out when the field is missing in Environment
+// or OtherEnvironment respectively.
+type FieldChange struct {
+	Path       string      `json:"path"`
+	Value      interface{} `json:"value,omitempty"`
+	OtherValue interface{} `json:"otherValue,omitempty"`
+}
+
+// ObjectDiff is an object that differs between two environments, matched by
+// its apiVersion, kind, namespace and name. Status is "added" for objects
+// only OtherEnvironment renders, "removed" for those only Environment
+// renders, and "changed" for the rest. Diff is a unified diff of the YAML of
+// the object.
+type ObjectDiff struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace,omitempty"`
+	Name       string        `json:"name"`
+	Status     string        `json:"status"`
+	Changes    []FieldChange `json:"changes,omitempty"`
+	Diff       string        `json:"diff"`
+}
+
+// DiffResponse lists the objects that differ between two environments,
+// sorted by apiVersion, kind, namespace and name, along with the unified
+// diff of all of them.
+type DiffResponse struct {
+	Objects []ObjectDiff `json:"objects"`
+	Diff    string       `json:"diff"`
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/ghodss/yaml"
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+const (
+	defaultEnvironment = "default"
+	defaultNamespace   = "default"
+	defaultK8sVersion  = "v1.7.0"
+)
+
+// k8sLibraries maps the Kubernetes versions an app can target to the
+// ksonnet-lib generated from their API, for versions that don't have a
+// library named after them in the library directory.
+var k8sLibraries = map[string]string{
+	"v1.7.0": "ksonnet.beta.2",
+}
+
+// nameRegexp matches names that are safe to use as file and directory names
+// in an app, such as environment names.
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// The files ks init writes, with the same contents.
+const (
+	componentParamsTemplate = `{
+  global: {
+    // User-defined global parameters; accessible to all component and environments, Ex:
+    // replicas: 4,
+  },
+  components: {
+    // Component-level parameters, defined initially from 'ks prototype use ...'
+    // Each object below should correspond to a component in the components/ directory
+  },
+}
+`
+	baseTemplate = `local components = std.extVar("__ksonnet/components");
+components + {
+  // Insert user-specified overrides here.
+}
+`
+	envMainTemplate = `local base = import "../base.libsonnet";
+local k = import "k.libsonnet";
+
+base + {
+  // Insert user-specified overrides here. For example if a component is named "nginx-deployment", you might have something like:
+  //   "nginx-deployment"+: k.deployment.mixin.metadata.labels({foo: "bar"})
+}
+`
+	envParamsTemplate = `local params = import "../../components/params.libsonnet";
+params + {
+  components +: {
+    // Insert component parameter overrides here. Ex:
+    // guestbook +: {
+    //   name: "guestbook-dev",
+    //   replicas: params.global.replicas,
+    // },
+  },
+}
+`
+)
+
+// appSpec is the contents of an app's app.yaml.
+type appSpec struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+}
+
+// envSpec is the contents of an environment's spec.json.
+type envSpec struct {
+	Server    string `json:"server"`
+	Namespace string `json:"namespace"`
+}
+
+// validateName returns an error if name can't be used as the name of the
+// given kind of thing in an app.
+func validateName(kind, name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid %s name %q - Names must start with a letter or digit, followed by letters, digits, '.', '_' or '-'", kind, name)
+	}
+	return nil
+}
+
+// validateEnvironmentName returns an error if name can't be the name of an
+// environment, including names of the files next to environments.
+func validateEnvironmentName(name string) error {
+	if err := validateName("environment", name); err != nil {
+		return err
+	}
+	if name == "base.libsonnet" {
+		return fmt.Errorf("Invalid environment name %q - It's the name of the environments' base template", name)
+	}
+	return nil
+}
+
+// validateServer returns an error if server isn't the URL of a Kubernetes API
+// server.
+func validateServer(server string) error {
+	if u, err := url.Parse(server); err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Invalid server %q - Expected the URL of a Kubernetes API server", server)
+	}
+	return nil
+}
+
+// k8sLibrary returns the name of the library to vendor into an app that
+// targets the given Kubernetes version.
+func k8sLibrary(version string) (string, error) {
+	libraries, err := listLibraries()
+	if err != nil {
+		return "", err
+	}
+	want, known := k8sLibraries[version]
+	for _, lib := range libraries {
+		if lib.Name == version || known && lib.Name == want {
+			return lib.Name, nil
+		}
+	}
+	return "", fmt.Errorf("No ksonnet-lib for Kubernetes version %q", version)
+}
+
+// libraryRef is the contents of an environment's .metadata/library.json,
+// which records the library the environment's code imports. The playground
+// resolves imports against the library in the library directory, and only
+// vendors it into exported archives.
+type libraryRef struct {
+	Name       string `json:"name"`
+	K8sVersion string `json:"k8sVersion"`
+}
+
+// newEnvironment returns the files of an environment directory, targeting
+// the given Kubernetes version with library.
+func newEnvironment(spec envSpec, library, k8sVersion string) (map[string]interface{}, error) {
+	specJSON, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	libJSON, err := json.MarshalIndent(libraryRef{Name: library, K8sVersion: k8sVersion}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"main.jsonnet":     envMainTemplate,
+		"params.libsonnet": envParamsTemplate,
+		"spec.json":        string(specJSON),
+		".metadata": map[string]interface{}{
+			"library.json": string(libJSON),
+		},
+	}, nil
+}
+
+// newApp scaffolds the app described by req, filling in defaults for the
+// optional fields.
+func newApp(req api.InitRequest) (api.InitResponse, int, error) {
+	if req.Environment == "" {
+		req.Environment = defaultEnvironment
+	}
+	if req.Namespace == "" {
+		req.Namespace = defaultNamespace
+	}
+	if req.K8sVersion == "" {
+		req.K8sVersion = defaultK8sVersion
+	}
+	if err := validateName("app", req.AppName); err != nil {
+		return api.InitResponse{}, http.StatusBadRequest, err
+	}
+	if err := validateEnvironmentName(req.Environment); err != nil {
+		return api.InitResponse{}, http.StatusBadRequest, err
+	}
+	if err := validateServer(req.Server); err != nil {
+		return api.InitResponse{}, http.StatusBadRequest, err
+	}
+
+	library, err := k8sLibrary(req.K8sVersion)
+	if err != nil {
+		return api.InitResponse{}, http.StatusBadRequest, err
+	}
+	env, err := newEnvironment(envSpec{Server: req.Server, Namespace: req.Namespace}, library, req.K8sVersion)
+	if err != nil {
+		return api.InitResponse{}, http.StatusInternalServerError, err
+	}
+	appYAML, err := yaml.Marshal(appSpec{
+		APIVersion: "0.0.1",
+		Kind:       "ksonnet.io/app",
+		Name:       req.AppName,
+		Version:    "0.0.1",
+	})
+	if err != nil {
+		return api.InitResponse{}, http.StatusInternalServerError, err
+	}
+
+	return api.InitResponse{
+		AppName: req.AppName,
+		AppYAML: string(appYAML),
+		Components: map[string]interface{}{
+			"params.libsonnet": componentParamsTemplate,
+		},
+		Environments: map[string]interface{}{
+			"base.libsonnet": baseTemplate,
+			req.Environment:  env,
+		},
+	}, http.StatusOK, nil
+}
+
+// ksInit scaffolds a new ksonnet app the way `ks init` does.
+func ksInit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxContentLength)
+	defer r.Body.Close()
+
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	var req api.InitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp, status, err := newApp(req)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
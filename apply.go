@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// lastAppliedAnnotation is where kubectl apply keeps the configuration it
+// last applied to an object, to tell which fields it should remove.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// The kinds of patch apply sends: strategic merge patches for the kinds
+// built into Kubernetes, and JSON merge patches for custom resources.
+const (
+	patchStrategic = "application/strategic-merge-patch+json"
+	patchMerge     = "application/merge-patch+json"
+)
+
+// The actions of an ObjectPreview.
+const (
+	applyCreate    = "create"
+	applyUpdate    = "update"
+	applyUnchanged = "unchanged"
+)
+
+// ApplyPreviewRequest previews applying the rendered Objects to a cluster
+// whose objects are Live, as dumped by kubectl get -o json. Both may be
+// objects, Lists or arrays of these. Objects without a namespace go in
+// Namespace, default if it's empty. Only live objects whose labels match
+// Selector are pruned, as with kubectl apply --prune -l.
+type ApplyPreviewRequest struct {
+	Objects   interface{}       `json:"objects"`
+	Live      interface{}       `json:"live"`
+	Namespace string            `json:"namespace,omitempty"`
+	Selector  map[string]string `json:"selector,omitempty"`
+}
+
+// ObjectPreview is what applying an object would do: create it, update it
+// with Patch, of type PatchType, or leave it unchanged. ImmutableChanges are
+// the fields the patch changes that the API server refuses to, which make
+// the apply fail.
+type ObjectPreview struct {
+	APIVersion       string       `json:"apiVersion"`
+	Kind             string       `json:"kind"`
+	Namespace        string       `json:"namespace,omitempty"`
+	Name             string       `json:"name"`
+	Action           string       `json:"action"`
+	PatchType        string       `json:"patchType,omitempty"`
+	Patch            interface{}  `json:"patch,omitempty"`
+	ImmutableChanges []Diagnostic `json:"immutableChanges,omitempty"`
+	Warnings         []string     `json:"warnings,omitempty"`
+}
+
+// PrunedObject is a live object that apply --prune would delete.
+type PrunedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// ApplyPreviewResponse previews each rendered object in order, and lists the
+// live objects that would be pruned.
+type ApplyPreviewResponse struct {
+	Objects []ObjectPreview `json:"objects"`
+	Prune   []PrunedObject  `json:"prune"`
+}
+
+// patchMergeKeys are the keys strategic merge patches match the items of
+// lists by, by the name of the list's field. The first key all the items
+// have is used, since e.g. ports are matched by containerPort in containers
+// but by port in services. Other lists are replaced as a whole.
+var patchMergeKeys = map[string][]string{
+	"conditions":       {"type"},
+	"containers":       {"name"},
+	"env":              {"name"},
+	"hostAliases":      {"ip"},
+	"imagePullSecrets": {"name"},
+	"initContainers":   {"name"},
+	"ownerReferences":  {"uid"},
+	"ports":            {"containerPort", "port"},
+	"volumeDevices":    {"devicePath"},
+	"volumeMounts":     {"mountPath"},
+	"volumes":          {"name"},
+}
+
+// immutableField is a field of some kinds that the API server doesn't let
+// updates change. If Except is set, the fields of the object at Path are
+// immutable except those. MutableIn lists the apiVersions in which the field
+// could still be changed.
+type immutableField struct {
+	Kinds     []string
+	Path      []string
+	Except    []string
+	MutableIn []string
+}
+
+var immutableFields = []immutableField{
+	{Kinds: []string{"Service"}, Path: []string{"spec", "clusterIP"}},
+	{
+		Kinds:     []string{"Deployment", "ReplicaSet", "DaemonSet"},
+		Path:      []string{"spec", "selector"},
+		MutableIn: []string{"extensions/v1beta1", "apps/v1beta1"},
+	},
+	{Kinds: []string{"StatefulSet"}, Path: []string{"spec"}, Except: []string{"replicas", "template", "updateStrategy"}},
+	{Kinds: []string{"Job"}, Path: []string{"spec", "selector"}},
+	{Kinds: []string{"Job"}, Path: []string{"spec", "template"}},
+	{Kinds: []string{"Job"}, Path: []string{"spec", "completions"}},
+	{Kinds: []string{"RoleBinding", "ClusterRoleBinding"}, Path: []string{"roleRef"}},
+	{Kinds: []string{"PersistentVolumeClaim"}, Path: []string{"spec"}, Except: []string{"resources"}},
+	{
+		Kinds:  []string{"Pod"},
+		Path:   []string{"spec"},
+		Except: []string{"activeDeadlineSeconds", "containers", "initContainers", "tolerations"},
+	},
+}
+
+// applyKey matches rendered objects to live ones. Live objects may have been
+// read at another version of their group, so only the group is compared.
+type applyKey struct {
+	Group, Kind, Namespace, Name string
+}
+
+func apiGroup(apiVersion string) string {
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		return apiVersion[:i]
+	}
+	return ""
+}
+
+// objectMeta returns the apiVersion, kind, namespace and name of object,
+// which must have all but the namespace.
+func objectMeta(object interface{}) (PrunedObject, map[string]interface{}, error) {
+	o, _ := object.(map[string]interface{})
+	metadata, _ := o["metadata"].(map[string]interface{})
+	var meta PrunedObject
+	meta.APIVersion, _ = o["apiVersion"].(string)
+	meta.Kind, _ = o["kind"].(string)
+	meta.Namespace, _ = metadata["namespace"].(string)
+	meta.Name, _ = metadata["name"].(string)
+	if meta.APIVersion == "" || meta.Kind == "" || meta.Name == "" {
+		return meta, nil, fmt.Errorf("Expected Kubernetes objects with an apiVersion, kind and metadata.name, got %s", jsonType(object))
+	}
+	return meta, o, nil
+}
+
+func (m PrunedObject) key() applyKey {
+	return applyKey{apiGroup(m.APIVersion), m.Kind, m.Namespace, m.Name}
+}
+
+// annotation returns the value of an annotation of object, if it has it.
+func annotation(object map[string]interface{}, name string) (string, bool) {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	value, ok := annotations[name].(string)
+	return value, ok
+}
+
+// withAnnotation returns a copy of object with an annotation set to value,
+// or removed if value is nil.
+func withAnnotation(object map[string]interface{}, name string, value *string) map[string]interface{} {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	oldAnnotations, _ := metadata["annotations"].(map[string]interface{})
+	annotations := map[string]interface{}{}
+	for k, v := range oldAnnotations {
+		annotations[k] = v
+	}
+	delete(annotations, name)
+	if value != nil {
+		annotations[name] = *value
+	}
+
+	copied := map[string]interface{}{}
+	for k, v := range object {
+		copied[k] = v
+	}
+	newMetadata := map[string]interface{}{}
+	for k, v := range metadata {
+		newMetadata[k] = v
+	}
+	delete(newMetadata, "annotations")
+	if len(annotations) > 0 {
+		newMetadata["annotations"] = annotations
+	}
+	copied["metadata"] = newMetadata
+	return copied
+}
+
+// withNamespace returns a copy of object in the given namespace, the way
+// apply puts objects without one in the namespace it applies to.
+func withNamespace(object map[string]interface{}, namespace string) map[string]interface{} {
+	metadata, _ := object["metadata"].(map[string]interface{})
+	copied := map[string]interface{}{}
+	for k, v := range object {
+		copied[k] = v
+	}
+	newMetadata := map[string]interface{}{"namespace": namespace}
+	for k, v := range metadata {
+		newMetadata[k] = v
+	}
+	copied["metadata"] = newMetadata
+	return copied
+}
+
+// lastApplied returns the configuration last applied to a live object, or
+// nil if there's none.
+func lastApplied(live map[string]interface{}) (map[string]interface{}, error) {
+	config, ok := annotation(live, lastAppliedAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var original map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &original); err != nil {
+		return nil, err
+	}
+	return original, nil
+}
+
+// withLastApplied returns object annotated with its own JSON, the way apply
+// records what it applied. If current records the same configuration, its
+// annotation is kept as it is, so that encoding it differently doesn't make
+// a change.
+func withLastApplied(object, current map[string]interface{}) map[string]interface{} {
+	object = withAnnotation(object, lastAppliedAnnotation, nil)
+	encoded, _ := json.Marshal(object)
+	config := string(encoded)
+	if original, err := lastApplied(current); err == nil && original != nil {
+		var applied interface{}
+		json.Unmarshal(encoded, &applied)
+		if reflect.DeepEqual(applied, interface{}(original)) {
+			config, _ = annotation(current, lastAppliedAnnotation)
+		}
+	}
+	return withAnnotation(object, lastAppliedAnnotation, &config)
+}
+
+// threeWayPatch returns the patch apply sends to turn current into modified:
+// fields modified sets to something else than current has, and nulls for
+// those original set but modified doesn't, which apply removes. Fields only
+// current has were set some other way and are kept. strategic merges lists
+// of objects by their patchMergeKeys instead of replacing them.
+func threeWayPatch(original, modified, current map[string]interface{}, strategic bool) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for k, m := range modified {
+		c, inCurrent := current[k]
+		o, _ := original[k].(map[string]interface{})
+		mObject, mIsObject := m.(map[string]interface{})
+		cObject, cIsObject := c.(map[string]interface{})
+		switch {
+		case mIsObject && cIsObject:
+			if sub := threeWayPatch(o, mObject, cObject, strategic); len(sub) > 0 {
+				patch[k] = sub
+			}
+		case strategic && mergeKey(k, original[k], m, c) != "":
+			if sub := mergeListPatch(mergeKey(k, original[k], m, c), original[k], m, c); len(sub) > 0 {
+				patch[k] = sub
+			}
+		case !inCurrent || !reflect.DeepEqual(m, c):
+			patch[k] = m
+		}
+	}
+	for k := range original {
+		if _, inModified := modified[k]; inModified {
+			continue
+		}
+		if _, inCurrent := current[k]; inCurrent {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// mergeKey returns the key the items of the lists of the field named field
+// are merged by, or "" if they're replaced.
+func mergeKey(field string, lists ...interface{}) string {
+	for _, key := range patchMergeKeys[field] {
+		found := true
+		for _, list := range lists {
+			if list == nil {
+				continue
+			}
+			items, ok := list.([]interface{})
+			if !ok {
+				return ""
+			}
+			for _, item := range items {
+				object, ok := item.(map[string]interface{})
+				if _, hasKey := object[key]; !ok || !hasKey {
+					found = false
+				}
+			}
+		}
+		if found {
+			return key
+		}
+	}
+	return ""
+}
+
+// mergeListPatch returns the strategic merge patch of a list merged by key:
+// the items of modified that aren't in current or differ from the item with
+// the same key, and deletions of the items original had that modified
+// doesn't.
+func mergeListPatch(key string, original, modified, current interface{}) []interface{} {
+	byKey := func(list interface{}) map[string]map[string]interface{} {
+		items := map[string]map[string]interface{}{}
+		array, _ := list.([]interface{})
+		for _, item := range array {
+			object := item.(map[string]interface{})
+			id, _ := json.Marshal(object[key])
+			items[string(id)] = object
+		}
+		return items
+	}
+	originalItems, currentItems, modifiedItems := byKey(original), byKey(current), byKey(modified)
+
+	var patch []interface{}
+	modifiedList, _ := modified.([]interface{})
+	for _, item := range modifiedList {
+		m := item.(map[string]interface{})
+		id, _ := json.Marshal(m[key])
+		c, ok := currentItems[string(id)]
+		if !ok {
+			patch = append(patch, m)
+			continue
+		}
+		if sub := threeWayPatch(originalItems[string(id)], m, c, true); len(sub) > 0 {
+			sub[key] = m[key]
+			patch = append(patch, sub)
+		}
+	}
+	originalList, _ := original.([]interface{})
+	for _, item := range originalList {
+		o := item.(map[string]interface{})
+		id, _ := json.Marshal(o[key])
+		_, inModified := modifiedItems[string(id)]
+		_, inCurrent := currentItems[string(id)]
+		if !inModified && inCurrent {
+			patch = append(patch, map[string]interface{}{key: o[key], "$patch": "delete"})
+		}
+	}
+	return patch
+}
+
+// isBuiltIn reports whether objects of the group are built into Kubernetes
+// rather than custom resources.
+func isBuiltIn(group string) bool {
+	return !strings.Contains(group, ".") || strings.HasSuffix(group, ".k8s.io")
+}
+
+// immutableChanges returns the immutable fields of the object that patch
+// changes.
+func immutableChanges(meta PrunedObject, live, patch map[string]interface{}) []Diagnostic {
+	var diags []Diagnostic
+	message := func(path string) Diagnostic {
+		return Diagnostic{path, fmt.Sprintf("%s of a %s can't be changed once it's created - apply would fail", strings.TrimPrefix(path, "."), meta.Kind)}
+	}
+	for _, field := range immutableFields {
+		if !contains(field.Kinds, meta.Kind) || contains(field.MutableIn, meta.APIVersion) {
+			continue
+		}
+		value, ok := pathValue(patch, field.Path)
+		if !ok {
+			continue
+		}
+		path := ""
+		for _, f := range field.Path {
+			path = fieldPath(path, f)
+		}
+		object, isObject := value.(map[string]interface{})
+		if len(field.Except) == 0 || !isObject {
+			diags = append(diags, message(path))
+			continue
+		}
+		var keys []string
+		for k := range object {
+			if !contains(field.Except, k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diags = append(diags, message(fieldPath(path, k)))
+		}
+	}
+
+	// ConfigMaps and Secrets can be made immutable
+	if (meta.Kind == "ConfigMap" || meta.Kind == "Secret") && apiGroup(meta.APIVersion) == "" && live["immutable"] == true {
+		for _, k := range []string{"binaryData", "data", "immutable", "stringData"} {
+			if _, ok := patch[k]; ok {
+				diags = append(diags, message(fieldPath("", k)))
+			}
+		}
+	}
+	return diags
+}
+
+// pathValue returns the value, even null, at path in object, if there's one.
+func pathValue(object map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = object
+	for _, f := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[f]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// previewObject previews applying object over current, the live object it
+// matches, or creating it if there's none.
+func previewObject(meta PrunedObject, object, current map[string]interface{}) ObjectPreview {
+	preview := ObjectPreview{
+		APIVersion: meta.APIVersion,
+		Kind:       meta.Kind,
+		Namespace:  meta.Namespace,
+		Name:       meta.Name,
+		Action:     applyCreate,
+	}
+	if current == nil {
+		return preview
+	}
+
+	original, err := lastApplied(current)
+	if err != nil {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("Ignored the invalid %s annotation of the live object: %v", lastAppliedAnnotation, err))
+		original = nil
+	} else if original == nil {
+		preview.Warnings = append(preview.Warnings, fmt.Sprintf("The live object has no %s annotation, so apply won't remove fields it doesn't set", lastAppliedAnnotation))
+	}
+	preview.PatchType = patchMerge
+	if isBuiltIn(apiGroup(meta.APIVersion)) {
+		preview.PatchType = patchStrategic
+	}
+	patch := threeWayPatch(original, withLastApplied(object, current), current, preview.PatchType == patchStrategic)
+	if len(patch) == 0 {
+		preview.Action = applyUnchanged
+		preview.PatchType = ""
+		return preview
+	}
+	preview.Action = applyUpdate
+	preview.Patch = patch
+	preview.ImmutableChanges = immutableChanges(meta, current, patch)
+	return preview
+}
+
+// applyPreviewHandler previews what kubectl apply would do with the objects
+// in the request to the live objects in it, without a cluster.
+func applyPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	var req ApplyPreviewRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+	objects, err := flattenObjects(req.Objects)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	liveObjects, err := flattenObjects(req.Live)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	live := map[applyKey]map[string]interface{}{}
+	for _, object := range liveObjects {
+		meta, o, err := objectMeta(object)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid live state: %v", err))
+			return
+		}
+		live[meta.key()] = o
+	}
+
+	resp := ApplyPreviewResponse{Objects: []ObjectPreview{}, Prune: []PrunedObject{}}
+	applied := map[applyKey]bool{}
+	namespaces := map[string]bool{}
+	for _, object := range objects {
+		meta, o, err := objectMeta(object)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// Objects without a namespace go in the default one, unless they're
+		// cluster-scoped
+		key := meta.key()
+		if _, ok := live[key]; !ok && meta.Namespace == "" && !clusterScopedKinds[meta.Kind] {
+			meta.Namespace = req.Namespace
+			key.Namespace = req.Namespace
+			o = withNamespace(o, req.Namespace)
+		}
+		applied[key] = true
+		if key.Namespace != "" {
+			namespaces[key.Namespace] = true
+		}
+		resp.Objects = append(resp.Objects, previewObject(meta, o, live[key]))
+	}
+
+	// apply --prune deletes the objects it applied before that aren't
+	// applied anymore, in the namespaces it applies to
+	for key, o := range live {
+		if applied[key] || (key.Namespace != "" && !namespaces[key.Namespace]) {
+			continue
+		}
+		if original, err := lastApplied(o); err != nil || original == nil {
+			continue
+		}
+		metadata, _ := o["metadata"].(map[string]interface{})
+		labels, _ := metadata["labels"].(map[string]interface{})
+		matches := true
+		for k, v := range req.Selector {
+			matches = matches && labels[k] == v
+		}
+		if matches {
+			meta, _, _ := objectMeta(o)
+			resp.Prune = append(resp.Prune, meta)
+		}
+	}
+	sort.Slice(resp.Prune, func(i, j int) bool {
+		a, b := resp.Prune[i], resp.Prune[j]
+		return objectKey{a.APIVersion, a.Kind, a.Namespace, a.Name}.String() < objectKey{b.APIVersion, b.Kind, b.Namespace, b.Name}.String()
+	})
+	writeJSON(w, resp)
+}
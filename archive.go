@@ -0,0 +1,420 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+// The formats apps can be exported as, by the file extension they're named
+// with.
+const (
+	archiveTarGz = "tar.gz"
+	archiveZip   = "zip"
+)
+
+var archiveMediaTypes = map[string]string{
+	archiveTarGz: "application/gzip",
+	archiveZip:   "application/zip",
+}
+
+// archiveDirs are the directories of a ks app that the playground doesn't
+// keep, which exported archives have empty so ks finds them.
+var archiveDirs = []string{"lib", "vendor"}
+
+// archiveFormat returns the format asked for by the format query parameter
+// of r, tar.gz by default.
+func archiveFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return archiveTarGz, nil
+	}
+	if _, ok := archiveMediaTypes[format]; !ok {
+		return "", fmt.Errorf("Invalid format %q - Expected %q or %q", format, archiveTarGz, archiveZip)
+	}
+	return format, nil
+}
+
+// vendorLibraries adds the files of the library each environment of app
+// records in its .metadata/library.json to the environment's .metadata
+// directory in files, the way ks vendors them. Apps leave them out, since
+// imports resolve against the library directory and they'd be larger than
+// --max-app-bytes, so they're only added to exported archives. Files the app
+// has of its own are kept.
+func vendorLibraries(files map[string]string, app api.InitResponse) error {
+	var walk func(prefix string, tree map[string]interface{}) error
+	walk = func(prefix string, tree map[string]interface{}) error {
+		for name, entry := range tree {
+			dir, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if name != ".metadata" {
+				if err := walk(prefix+name+"/", dir); err != nil {
+					return err
+				}
+				continue
+			}
+			refJSON, ok := dir["library.json"].(string)
+			if !ok {
+				continue
+			}
+			var ref libraryRef
+			if err := json.Unmarshal([]byte(refJSON), &ref); err != nil || validateName("library", ref.Name) != nil {
+				continue
+			}
+			libFiles, err := ioutil.ReadDir(filepath.Join(config.LibraryDir, ref.Name))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			for _, f := range libFiles {
+				p := prefix + ".metadata/" + f.Name()
+				if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") || files[p] != "" {
+					continue
+				}
+				contents, err := ioutil.ReadFile(filepath.Join(config.LibraryDir, ref.Name, f.Name()))
+				if err != nil {
+					return err
+				}
+				files[p] = string(contents)
+			}
+		}
+		return nil
+	}
+	return walk("environments/", app.Environments)
+}
+
+// vendoredFile reports whether the file at p, relative to the root of an
+// app, is one vendorLibraries adds.
+func vendoredFile(p string) bool {
+	return strings.HasPrefix(p, "environments/") && path.Base(path.Dir(p)) == ".metadata" && path.Base(p) != "library.json"
+}
+
+// writeArchive writes app to w as an archive in the given format, laid out
+// the way ks expects under a directory named after the app, with the
+// libraries its environments use vendored.
+func writeArchive(w io.Writer, format string, app api.InitResponse) error {
+	files := appFiles(app)
+	if err := vendorLibraries(files, app); err != nil {
+		return err
+	}
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	root := app.AppName + "/"
+	now := time.Now()
+
+	if format == archiveZip {
+		zw := zip.NewWriter(w)
+		for _, dir := range archiveDirs {
+			if _, err := zw.Create(root + dir + "/"); err != nil {
+				return err
+			}
+		}
+		for _, p := range paths {
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: root + p, Method: zip.Deflate, Modified: now})
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(f, files[p]); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, dir := range archiveDirs {
+		hdr := &tar.Header{Name: root + dir + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	for _, p := range paths {
+		hdr := &tar.Header{
+			Name:     root + p,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[p])),
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, files[p]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// archiveReader reads the files of an uploaded archive, holding them to the
+// limits on the number of files and on their size once decompressed.
+type archiveReader struct {
+	files map[string]string
+	size  int64
+}
+
+// cleanArchivePath returns the path of an archive entry relative to the root
+// of the archive, or an error if it's absolute or leads out of the archive.
+func cleanArchivePath(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "./"))
+	if name == "" || path.IsAbs(name) || strings.ContainsAny(name, "\\\x00") ||
+		cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", badInputError(fmt.Sprintf("Invalid path %q in the archive", name))
+	}
+	return cleaned, nil
+}
+
+// add reads the file with the given name in the archive from r.
+func (a *archiveReader) add(name string, r io.Reader) error {
+	p, err := cleanArchivePath(name)
+	if err != nil {
+		return err
+	}
+	if len(a.files) >= config.MaxArchiveFiles {
+		return badInputError(fmt.Sprintf("The archive has more than %d files", config.MaxArchiveFiles))
+	}
+	// Read one byte past the limit to tell when it's crossed
+	contents, err := ioutil.ReadAll(io.LimitReader(r, config.MaxAppBytes-a.size+1))
+	if err != nil {
+		return badInputError(fmt.Sprintf("Could not read %q from the archive: %v", name, err))
+	}
+	if a.size += int64(len(contents)); a.size > config.MaxAppBytes {
+		return badInputError(fmt.Sprintf("The files in the archive add up to more than %d bytes", config.MaxAppBytes))
+	}
+	a.files[p] = string(contents)
+	return nil
+}
+
+func (a *archiveReader) readTarGz(data []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return badInputError(fmt.Sprintf("Invalid tar.gz archive: %v", err))
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return badInputError(fmt.Sprintf("Invalid tar.gz archive: %v", err))
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if err := a.add(hdr.Name, tr); err != nil {
+				return err
+			}
+		case tar.TypeDir:
+			if _, err := cleanArchivePath(hdr.Name); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			return badInputError(fmt.Sprintf("%q in the archive isn't a regular file - links and special files aren't allowed", hdr.Name))
+		}
+	}
+}
+
+func (a *archiveReader) readZip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return badInputError(fmt.Sprintf("Invalid zip archive: %v", err))
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if _, err := cleanArchivePath(f.Name); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return badInputError(fmt.Sprintf("Could not read %q from the archive: %v", f.Name, err))
+			}
+			err = a.add(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return badInputError(fmt.Sprintf("%q in the archive isn't a regular file - links and special files aren't allowed", f.Name))
+		}
+	}
+	return nil
+}
+
+// readArchive reads a ks app from a tar.gz or zip archive. The app is found
+// by its app.yaml, which may be at the root of the archive or in a directory.
+// Files other than app.yaml and those in components/ and environments/ are
+// left out, as are vendored libraries.
+func readArchive(data []byte) (api.InitResponse, error) {
+	a := &archiveReader{files: map[string]string{}}
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		err = a.readTarGz(data)
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		err = a.readZip(data)
+	default:
+		err = badInputError("Expected a tar.gz or zip archive")
+	}
+	if err != nil {
+		return api.InitResponse{}, err
+	}
+
+	// The app's root is the shallowest directory with an app.yaml
+	var roots []string
+	for p := range a.files {
+		if path.Base(p) != "app.yaml" {
+			continue
+		}
+		if len(roots) > 0 && strings.Count(p, "/") > strings.Count(roots[0], "/") {
+			continue
+		}
+		if len(roots) > 0 && strings.Count(p, "/") < strings.Count(roots[0], "/") {
+			roots = nil
+		}
+		roots = append(roots, p)
+	}
+	if len(roots) == 0 {
+		return api.InitResponse{}, badInputError("The archive has no app.yaml")
+	} else if len(roots) > 1 {
+		return api.InitResponse{}, badInputError("The archive has more than one app")
+	}
+	root := path.Dir(roots[0])
+
+	app := api.InitResponse{
+		Components:   map[string]interface{}{},
+		Environments: map[string]interface{}{},
+	}
+	for p, contents := range a.files {
+		rel := p
+		if root != "." {
+			if !strings.HasPrefix(p, root+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(p, root+"/")
+		}
+		if rel != "app.yaml" && !strings.HasPrefix(rel, "components/") && !strings.HasPrefix(rel, "environments/") ||
+			vendoredFile(rel) {
+			continue
+		}
+		if err := writeFile(&app, rel, contents); err != nil {
+			return api.InitResponse{}, err
+		}
+	}
+
+	var spec appSpec
+	if err := yaml.Unmarshal([]byte(app.AppYAML), &spec); err != nil {
+		return api.InitResponse{}, badInputError(fmt.Sprintf("Invalid app.yaml: %v", err))
+	}
+	app.AppName = spec.Name
+	if app.AppName == "" && root != "." {
+		app.AppName = path.Base(root)
+	}
+	if err := validateName("app", app.AppName); err != nil {
+		return api.InitResponse{}, badInputError(err.Error())
+	}
+	return app, nil
+}
+
+// writeArchiveResponse answers with app as an archive in the format asked
+// for by r.
+func writeArchiveResponse(w http.ResponseWriter, r *http.Request, app api.InitResponse) {
+	format, err := archiveFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateName("app", app.AppName); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeArchive(&buf, format, app); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", archiveMediaTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, app.AppName, format))
+	w.Write(buf.Bytes())
+}
+
+// exportHandler answers with the app in the request, in the shape of an
+// api.InitResponse, as an archive.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	var app api.InitResponse
+	if !readAppRequest(w, r, &app) {
+		return
+	}
+	writeArchiveResponse(w, r, app)
+}
+
+// archiveHandler answers with the current app of a workspace as an archive.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	app, err := workspaces.revision(workspaceID(r), 0)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeArchiveResponse(w, r, app)
+}
+
+// importHandler keeps the app in an uploaded archive as a new workspace.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxArchiveBytes)
+	defer r.Body.Close()
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		abuse.record(client, abuseTooLarge)
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("Request too large - Archives must be smaller than %v bytes", config.MaxArchiveBytes))
+		return
+	}
+
+	app, err := readArchive(data)
+	if _, ok := err.(badInputError); ok {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	createWorkspace(w, client, app)
+}
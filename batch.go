@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// batchConcurrency is how many distinct requests of a batch are evaluated
+// at the same time.
+const batchConcurrency = 4
+
+// BatchRequest is a list of requests to evaluate in one round trip.
+type BatchRequest struct {
+	Requests []JsonnetRequest `json:"requests"`
+}
+
+// BatchResponse holds the response to each request of a BatchRequest, in
+// the same order. Each response carries its own error, so the batch as a
+// whole succeeds even if some of its requests failed.
+type BatchResponse struct {
+	Responses []JsonnetResponse `json:"responses"`
+}
+
+// checkBatchSize returns an error if a batch of n requests is too big.
+func checkBatchSize(n int) error {
+	if n > config.MaxBatchSize {
+		return fmt.Errorf("Batch too large - Batches may have at most %v requests", config.MaxBatchSize)
+	}
+	return nil
+}
+
+// evaluateBatch evaluates reqs for client, calling emit with each result in
+// order as soon as it and all the results before it are ready. Identical
+// requests are only evaluated once, and each distinct request goes through
+// the cache and the rate limiter on its own, so only cache misses count
+// against the limit.
+func evaluateBatch(ctx context.Context, client string, reqs []JsonnetRequest, emit func(i int, result CachedResult) error) error {
+	// distinct[k] is the index of the first request with key k, and
+	// firstOf[i] the index of the first request identical to reqs[i].
+	distinct := map[string]int{}
+	firstOf := make([]int, len(reqs))
+	for i, req := range reqs {
+		keyBytes, _ := json.Marshal(req)
+		if first, ok := distinct[string(keyBytes)]; ok {
+			firstOf[i] = first
+			continue
+		}
+		distinct[string(keyBytes)] = i
+		firstOf[i] = i
+	}
+
+	results := make([]CachedResult, len(reqs))
+	done := make([]chan struct{}, len(reqs))
+	jobs := make(chan int)
+	for _, i := range distinct {
+		done[i] = make(chan struct{})
+	}
+
+	// Stopping early cancels the evaluations still running, and waits for
+	// them to be killed.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for w := 0; w < batchConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if int64(len(reqs[i].Code)) > config.MaxContentLength {
+					abuse.record(client, abuseTooLarge)
+					results[i] = errorResult(http.StatusRequestEntityTooLarge, errTooLarge())
+				} else {
+					results[i] = evalEndpoint.evaluate(ctx, client, reqs[i])
+				}
+				close(done[i])
+			}
+		}()
+	}
+
+	// Queue the distinct requests in order, so the earliest results, which
+	// emit is waiting on, are computed first.
+	go func() {
+		defer close(jobs)
+		for i := range reqs {
+			if firstOf[i] != i {
+				continue
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for i := range reqs {
+		select {
+		case <-done[firstOf[i]]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := emit(i, results[firstOf[i]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxBatchBytes)
+	defer r.Body.Close()
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		abuse.record(client, abuseTooLarge)
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("Request too large - Batches must be smaller than %v bytes", config.MaxBatchBytes))
+		return
+	}
+
+	var req BatchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := checkBatchSize(len(req.Requests)); err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	resp := BatchResponse{Responses: make([]JsonnetResponse, len(req.Requests))}
+	err = evaluateBatch(r.Context(), client, req.Requests, func(i int, result CachedResult) error {
+		resp.Responses[i] = result.Response
+		return nil
+	})
+	if err != nil {
+		// The client went away
+		return
+	}
+
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		log.Fatalf("Failed to serialize batch JSON response:\n%v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
@@ -52,3 +52,18 @@ docker run --rm -t \
     --link "${CONTAINER_NAME}:${CONTAINER_NAME}" \
     "${TEST_IMAGE}" \
     "$(pwd)/test-files/hit-rate-limit.sh" "${CONTAINER_NAME}:8080" "$(pwd)/test-files/sample.jsonnet"
+
+docker kill "${CONTAINER_NAME}"
+docker rm "${CONTAINER_NAME}"
+
+## Tests pt3
+# Run the image in the background behind a pretend proxy, with a low abuse
+# threshold
+docker run -d --name "${CONTAINER_NAME}" -v "$(pwd):$(pwd)" "${IMAGE}" /ksonnet-playground --trusted-proxies 1 --abuse-max-too-large 2 \
+    --max-workspaces-per-client 2 --abuse-max-workspace-limited 1
+
+docker run --rm -t \
+    -v "$(pwd):$(pwd)" \
+    --link "${CONTAINER_NAME}:${CONTAINER_NAME}" \
+    "${TEST_IMAGE}" \
+    "$(pwd)/test-files/abuse.sh" "${CONTAINER_NAME}:8080"
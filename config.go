@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"strings"
 	"time"
 
 	flag "github.com/spf13/pflag"
@@ -14,32 +15,121 @@ type Config struct {
 	RateLimitBurst    int
 	JsonnetRunTimeout time.Duration
 	ExtraImportPath   string
-	SkipCorsCheck     bool
-	MaxContentLength  int64
-	CacheSize         int64
+	LibraryDir        string
+	PrototypeDir      string
+	SchemaDir         string
+
+	MaxSpecBytes           int64
+	MaxGeneratedLibraries  int
+	MaxLibrariesPerOwner   int
+	LibraryIdle            time.Duration
+	LibraryGenerateTimeout time.Duration
+	LiveDebounce           time.Duration
+	GRPCPort               int
+	GRPCTLSCert            string
+	GRPCTLSKey             string
+	SkipCorsCheck          bool
+	MaxContentLength       int64
+	CacheSize              int64
+	MaxBatchSize           int
+	MaxBatchBytes          int64
+	MaxAppBytes            int64
+	JobRunTimeout          time.Duration
+	JobAPIKeys             []string
+	JobRetention           time.Duration
+	MaxJobs                int
+	MaxJobsPerClient       int
+	WorkspaceIdle          time.Duration
+	MaxWorkspaces          int
+	MaxWorkspacesPerClient int
+	MaxRevisions           int
+	MaxArchiveBytes        int64
+	MaxArchiveFiles        int
+
+	TrustedProxies           int
+	ClientRateLimit          rate.Limit
+	ClientRateLimitBurst     int
+	AbuseWindow              time.Duration
+	AbuseBanDuration         time.Duration
+	AbuseMaxTimeouts         int
+	AbuseMaxTooLarge         int
+	AbuseMaxRateLimited      int
+	AbuseMaxWorkspaceLimited int
 }
 
 var config = &Config{}
 
 func init() {
 	var timeoutSeconds int
-	var rateLimit float64
+	var rateLimit, clientRateLimit float64
+	var abuseWindowSeconds, abuseBanSeconds int
+	var liveDebounceMillis int
+	var jobTimeoutSeconds, jobRetentionSeconds int
+	var workspaceIdleSeconds int
+	var libraryTimeoutSeconds, libraryIdleSeconds int
 
 	flag.Float64Var(&rateLimit, "rate-limit", 20.0, "Rate limit for API calls that aren't served from cache")
 	flag.IntVar(&config.RateLimitBurst, "rate-limit-burst", 30, "Allowed burst for the rate limit")
+	flag.Float64Var(&clientRateLimit, "client-rate-limit", 10.0, "Rate limit for each client's API calls that aren't served from cache (0 to disable)")
+	flag.IntVar(&config.ClientRateLimitBurst, "client-rate-limit-burst", 50, "Allowed burst for each client's rate limit, which lets a full batch through")
 	flag.Int64Var(&config.MaxContentLength, "max-content-length", 10240, "Maximum content length of input jsonnet")
+	flag.IntVar(&config.MaxBatchSize, "max-batch-size", 50, "Maximum number of requests in a batch")
+	flag.Int64Var(&config.MaxBatchBytes, "max-batch-bytes", 262144, "Maximum content length of a batch request")
+	flag.Int64Var(&config.MaxAppBytes, "max-app-bytes", 262144, "Maximum content length of requests carrying a whole ksonnet app")
 	flag.IntVar(&timeoutSeconds, "jsonnet-run-timeout", 5, "Maximum duration to run jsonnet command for requests, in seconds")
+	flag.IntVar(&jobTimeoutSeconds, "job-run-timeout", 60, "Maximum duration to run jsonnet for jobs submitted with a job API key, in seconds")
+	flag.StringSliceVar(&config.JobAPIKeys, "job-api-keys", nil, "API keys whose jobs may run for the job run timeout (also read from JOB_API_KEYS)")
+	flag.IntVar(&jobRetentionSeconds, "job-retention", 600, "How long the outcome of a finished job is kept, in seconds")
+	flag.IntVar(&config.MaxJobs, "max-jobs", 20, "Maximum number of jobs running at once")
+	flag.IntVar(&config.MaxJobsPerClient, "max-jobs-per-client", 5, "Maximum number of jobs running at once for each client or job API key")
+	flag.IntVar(&workspaceIdleSeconds, "workspace-idle-timeout", 1800, "How long a workspace is kept after it was last used, in seconds")
+	flag.IntVar(&config.MaxWorkspaces, "max-workspaces", 100, "Maximum number of workspaces kept at once")
+	flag.IntVar(&config.MaxWorkspacesPerClient, "max-workspaces-per-client", 10, "Maximum number of workspaces kept at once for each client that created them")
+	flag.IntVar(&config.MaxRevisions, "max-workspace-revisions", 20, "Number of revisions kept for each workspace, counting the current one")
+	flag.Int64Var(&config.MaxArchiveBytes, "max-archive-bytes", 1048576, "Maximum size of an uploaded app archive, before decompressing it")
+	flag.IntVar(&config.MaxArchiveFiles, "max-archive-files", 1000, "Maximum number of files in an uploaded app archive")
 	flag.StringVar(&config.ExtraImportPath, "extra-import-path", "ksonnet.beta.2", "Additional path to search for jsonnet import files")
+	flag.StringVar(&config.LibraryDir, "library-dir", ".", "Directory holding the jsonnet library versions listed by the libraries endpoint")
+	flag.StringVar(&config.PrototypeDir, "prototype-dir", "prototypes", "Directory holding the prototypes that components can be generated from")
+	flag.StringVar(&config.SchemaDir, "schema-dir", "schemas", "Directory holding the Kubernetes OpenAPI specs that objects are validated against, named after their version, e.g. v1.7.0.json")
+	flag.Int64Var(&config.MaxSpecBytes, "max-spec-bytes", 8388608, "Maximum size of the OpenAPI specs and CRDs libraries are generated from")
+	flag.IntVar(&config.MaxGeneratedLibraries, "max-generated-libraries", 20, "Maximum number of generated libraries kept in the library directory")
+	flag.IntVar(&config.MaxLibrariesPerOwner, "max-generated-libraries-per-owner", 3, "Maximum number of generated libraries kept for each client or API key that generated them")
+	flag.IntVar(&libraryIdleSeconds, "generated-library-idle-timeout", 604800, "How long a generated library is kept after it was last used, in seconds (0 to keep them)")
+	flag.IntVar(&libraryTimeoutSeconds, "library-generate-timeout", 10, "Maximum duration to generate a library for, in seconds")
 	flag.BoolVar(&config.SkipCorsCheck, "skip-cors-check", false, "Set this flag to allow all origins to access the API")
 	flag.Int64Var(&config.CacheSize, "cache-size", 10000, "Number of request entries to LRU cache")
+	flag.IntVar(&liveDebounceMillis, "live-debounce", 300, "How long a live session waits for further edits before evaluating, in milliseconds")
+	flag.IntVar(&config.GRPCPort, "grpc-port", 9090, "Port to serve the gRPC API on (0 to disable)")
+	flag.StringVar(&config.GRPCTLSCert, "grpc-tls-cert", "", "TLS certificate file for the gRPC API (a self-signed one is generated if unset)")
+	flag.StringVar(&config.GRPCTLSKey, "grpc-tls-key", "", "TLS key file for the gRPC API")
+	flag.IntVar(&config.TrustedProxies, "trusted-proxies", 0, "Number of proxies in front of the server that append to X-Forwarded-For; clients are identified by the hop the outermost one added instead of the remote address (0 to disable)")
+	flag.IntVar(&abuseWindowSeconds, "abuse-window", 60, "Sliding window over which abusive requests are counted per client, in seconds")
+	flag.IntVar(&abuseBanSeconds, "abuse-ban-duration", 600, "How long a client that crosses an abuse threshold is banned for, in seconds")
+	flag.IntVar(&config.AbuseMaxTimeouts, "abuse-max-timeouts", 5, "Jsonnet timeouts per client within the abuse window before a ban (0 to disable)")
+	flag.IntVar(&config.AbuseMaxTooLarge, "abuse-max-too-large", 10, "Oversized requests per client within the abuse window before a ban (0 to disable)")
+	flag.IntVar(&config.AbuseMaxRateLimited, "abuse-max-rate-limited", 30, "Requests per client refused by its own rate limit within the abuse window before a ban (0 to disable)")
+	flag.IntVar(&config.AbuseMaxWorkspaceLimited, "abuse-max-workspace-limited", 10, "Workspaces per client refused for going over its own limit within the abuse window before a ban (0 to disable)")
 
 	flag.Parse()
 
 	config.RateLimit = rate.Limit(rateLimit)
+	config.ClientRateLimit = rate.Limit(clientRateLimit)
 	config.JsonnetRunTimeout = time.Duration(timeoutSeconds) * time.Second
+	config.LiveDebounce = time.Duration(liveDebounceMillis) * time.Millisecond
+	config.AbuseWindow = time.Duration(abuseWindowSeconds) * time.Second
+	config.AbuseBanDuration = time.Duration(abuseBanSeconds) * time.Second
+	config.JobRunTimeout = time.Duration(jobTimeoutSeconds) * time.Second
+	config.JobRetention = time.Duration(jobRetentionSeconds) * time.Second
+	config.WorkspaceIdle = time.Duration(workspaceIdleSeconds) * time.Second
+	config.LibraryGenerateTimeout = time.Duration(libraryTimeoutSeconds) * time.Second
+	config.LibraryIdle = time.Duration(libraryIdleSeconds) * time.Second
 
 	if os.Getenv("SKIP_CORS_CHECK") == "true" {
 		config.SkipCorsCheck = true
 	}
+	if keys := os.Getenv("JOB_API_KEYS"); keys != "" {
+		config.JobAPIKeys = append(config.JobAPIKeys, strings.Split(keys, ",")...)
+	}
 
 }
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/ghodss/yaml"
+)
+
+// ConvertRequest asks for the Kubernetes objects in a multi-document YAML
+// stream as jsonnet built with the builders of a library, the default one if
+// Library is empty.
+type ConvertRequest struct {
+	YAML    string `json:"yaml"`
+	Library string `json:"library,omitempty"`
+}
+
+// ConvertResponse is jsonnet that evaluates to the converted objects: the
+// object itself if there was one, or a List of them. It imports the builders
+// as k.libsonnet, so it's evaluated with the same library in the request.
+type ConvertResponse struct {
+	Code    string            `json:"code"`
+	Library string            `json:"library"`
+	Objects []ConvertedObject `json:"objects"`
+}
+
+// ConvertedObject tells how an object is built in the converted code: with
+// the constructor of its kind and mixins for the rest of its fields, with
+// mixins only, or as a literal when the library has no builders that give
+// back the same object.
+type ConvertedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name,omitempty"`
+	Style      string `json:"style"`
+}
+
+// The ways an object can be built, from the most to the least idiomatic.
+// Objects whose code doesn't evaluate back to them fall back to the next one.
+const (
+	styleConstructor = iota
+	styleMixins
+	styleLiteral
+)
+
+var styleNames = []string{"constructor", "mixins", "literal"}
+
+// constructor is a function of a builder that sets the fields at the given
+// paths, in the order it takes them.
+type constructor struct {
+	fn     string
+	fields []string
+}
+
+// constructors are the constructors of ksonnet-lib builders, keyed by the
+// kind they build or, for the items of arrays, the name of the array field.
+// The first one that exists in the library, takes that many arguments and
+// whose fields are all set is used. Kinds with none that fit are built with a new() taking no
+// arguments, if they have one, as generated libraries do.
+var constructors = map[string][]constructor{
+	"Deployment": {
+		{"new", []string{"metadata.name", "spec.replicas", "spec.template.spec.containers", "spec.template.metadata.labels"}},
+		{"new", []string{"metadata.name", "spec.replicas", "spec.template.spec.containers"}},
+	},
+	"Service":    {{"new", []string{"metadata.name", "spec.selector", "spec.ports"}}},
+	"ConfigMap":  {{"new", []string{"metadata.name", "data"}}},
+	"Namespace":  {{"new", []string{"metadata.name"}}},
+	"containers": {{"new", []string{"name", "image"}}},
+	"env":        {{"new", []string{"name", "value"}}},
+	"ports": {
+		{"newNamed", []string{"name", "containerPort"}},
+		{"new", []string{"containerPort"}},
+		{"newNamed", []string{"name", "port", "targetPort"}},
+		{"new", []string{"port", "targetPort"}},
+	},
+}
+
+// documentSeparator matches the lines between the documents of a YAML
+// stream.
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*(#.*)?$`)
+
+// parseManifests returns the Kubernetes objects in a YAML stream, with the
+// items of Lists in their place.
+func parseManifests(stream string) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for i, doc := range documentSeparator.Split(stream, -1) {
+		data, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, badInputError(fmt.Sprintf("Invalid YAML in document %d: %v", i+1, err))
+		}
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, badInputError(fmt.Sprintf("Invalid YAML in document %d: %v", i+1, err))
+		}
+		if value == nil {
+			continue
+		}
+		items := []interface{}{value}
+		if object, ok := value.(map[string]interface{}); ok && object["kind"] == "List" {
+			items, _ = object["items"].([]interface{})
+		}
+		for _, item := range items {
+			object, ok := item.(map[string]interface{})
+			apiVersion, _ := object["apiVersion"].(string)
+			kind, _ := object["kind"].(string)
+			if !ok || apiVersion == "" || kind == "" || kind == "List" {
+				return nil, badInputError(fmt.Sprintf("Document %d isn't a Kubernetes object with an apiVersion and kind", i+1))
+			}
+			objects = append(objects, object)
+		}
+	}
+	if len(objects) == 0 {
+		return nil, badInputError("No Kubernetes objects in the YAML")
+	}
+	return objects, nil
+}
+
+// kindPath is the path in k.libsonnet of the builder of an object's kind.
+func kindPath(object map[string]interface{}) []string {
+	apiVersion := object["apiVersion"].(string)
+	group, version := "", apiVersion
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		group, version = apiVersion[:i], apiVersion[i+1:]
+	}
+	return []string{groupKey(group), version, lowerCamel(object["kind"].(string))}
+}
+
+// pathKey joins a path in the library into a map key.
+func pathKey(path ...[]string) string {
+	var parts []string
+	for _, p := range path {
+		parts = append(parts, p...)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// join returns a new path of path followed by elems.
+func join(path []string, elems ...string) []string {
+	return append(append([]string{}, path...), elems...)
+}
+
+// objectArray returns value as an array of objects, if it's one.
+func objectArray(value interface{}) ([]map[string]interface{}, bool) {
+	array, ok := value.([]interface{})
+	if !ok || len(array) == 0 {
+		return nil, false
+	}
+	objects := make([]map[string]interface{}, len(array))
+	for i, v := range array {
+		if objects[i], ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return objects, true
+}
+
+// objectKeys returns the keys of object in order.
+func objectKeys(object map[string]interface{}) []string {
+	var keys []string
+	for k := range object {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// libraryPaths collects the paths in the library that converting objects
+// could use, to find out which exist in one evaluation.
+type libraryPaths struct {
+	seen  map[string]bool
+	paths [][]string
+}
+
+func (p *libraryPaths) add(path []string) {
+	if key := pathKey(path); !p.seen[key] {
+		p.seen[key] = true
+		p.paths = append(p.paths, path)
+	}
+}
+
+func (p *libraryPaths) addConstructors(builder []string, key string) {
+	for _, c := range constructors[key] {
+		p.add(join(builder, c.fn))
+	}
+}
+
+// addFields adds the setters and mixins the fields of value could be set
+// with, under the builder or mixin at path.
+func (p *libraryPaths) addFields(path []string, isType bool, value map[string]interface{}) {
+	for f, v := range value {
+		mixin := join(path, f)
+		if isType {
+			mixin = join(path, "mixin", f)
+		}
+		p.add(join(path, f))
+		p.add(mixin)
+		p.add(join(mixin, "mixinInstance"))
+		if object, ok := v.(map[string]interface{}); ok {
+			p.addFields(mixin, false, object)
+		}
+		if items, ok := objectArray(v); ok {
+			itemType := join(path, f+"Type")
+			p.add(itemType)
+			p.addConstructors(itemType, f)
+			for _, item := range items {
+				p.addFields(itemType, true, item)
+			}
+		}
+	}
+}
+
+// libraryType is what's at a path in the library: its std.type, or "" if
+// there's nothing there, and the number of parameters of functions.
+type libraryType struct {
+	Type   string `json:"type"`
+	Params int    `json:"params"`
+}
+
+// probeCode is jsonnet evaluating to the libraryType at each of the paths in
+// the library.
+func probeCode(paths [][]string) string {
+	encoded, _ := json.Marshal(paths)
+	return `local k = import "k.libsonnet";
+local typeAt(o, path, i) =
+  if i == std.length(path) then {
+    type: std.type(o),
+    params: if std.type(o) == "function" then std.length(o) else 0,
+  }
+  else if std.type(o) == "object" && std.objectHasAll(o, path[i]) then typeAt(o[path[i]], path, i + 1)
+  else {type: "", params: 0};
+std.map(function(path) typeAt(k, path, 0), ` + string(encoded) + ")\n"
+}
+
+// builderRef is a builder, or a mixin of one, in the code being written:
+// the expression for it and its path in the library. owner is the local the
+// expression starts with, and item is set if it's the type of the items of
+// an array.
+type builderRef struct {
+	expr  string
+	path  []string
+	owner string
+	item  bool
+}
+
+// localDecl is a local the converted code declares a builder in.
+type localDecl struct {
+	name, expr string
+}
+
+// converter writes jsonnet that rebuilds objects with the builders of a
+// library. types maps the paths in the library found by libraryPaths to
+// what's there.
+type converter struct {
+	types  map[string]libraryType
+	style  int
+	locals []localDecl
+	names  map[string]string
+	taken  map[string]bool
+}
+
+func newConverter(types map[string]libraryType) *converter {
+	return &converter{types: types, names: map[string]string{}, taken: map[string]bool{"k": true}}
+}
+
+func (c *converter) has(typ string, path ...[]string) bool {
+	return c.types[pathKey(path...)].Type == typ
+}
+
+// hasFunction reports whether there's a function taking the given number of
+// arguments at path. Parameters with defaults can't be told apart, so it
+// only needs to have at least that many, except that calls without
+// arguments need a function without parameters.
+func (c *converter) hasFunction(args int, path ...[]string) bool {
+	t := c.types[pathKey(path...)]
+	return t.Type == "function" && t.Params >= args && (args > 0 || t.Params == 0)
+}
+
+// unique returns the first of the names, or the last one numbered, that
+// isn't taken by another local, and takes it.
+func (c *converter) unique(names ...string) string {
+	name := names[len(names)-1]
+	for _, n := range names {
+		if !c.taken[n] && !jsonnetKeywords[n] {
+			name = n
+			break
+		}
+	}
+	for i := 2; c.taken[name] || jsonnetKeywords[name]; i++ {
+		name = fmt.Sprintf("%s%d", names[len(names)-1], i)
+	}
+	c.taken[name] = true
+	return name
+}
+
+// declare returns the local holding the builder at path, adding it named
+// after the first of names that's free if there isn't one yet.
+func (c *converter) declare(path []string, expr string, names ...string) string {
+	key := pathKey(path)
+	if name, ok := c.names[key]; ok {
+		return name
+	}
+	name := c.unique(names...)
+	c.names[key] = name
+	c.locals = append(c.locals, localDecl{name, expr})
+	return name
+}
+
+// access returns expr followed by field accesses of the given fields.
+func access(expr string, fields ...string) string {
+	for _, f := range fields {
+		if identifierRegexp.MatchString(f) && !jsonnetKeywords[f] {
+			expr += "." + f
+		} else {
+			expr += "[" + jsonnetString(f) + "]"
+		}
+	}
+	return expr
+}
+
+// lookup returns the value at a dotted path in object.
+func lookup(object map[string]interface{}, dotted string) (interface{}, bool) {
+	parts := strings.Split(dotted, ".")
+	for _, part := range parts[:len(parts)-1] {
+		var ok bool
+		if object, ok = object[part].(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	value, ok := object[parts[len(parts)-1]]
+	return value, ok
+}
+
+// construct returns a call to the first constructor under key that fits
+// value, marking the fields it sets as consumed.
+func (c *converter) construct(b builderRef, key string, value map[string]interface{}, consumed map[string]bool, indent string) (string, bool) {
+	for _, ctor := range constructors[key] {
+		if !c.hasFunction(len(ctor.fields), b.path, []string{ctor.fn}) {
+			continue
+		}
+		var args []string
+		for _, dotted := range ctor.fields {
+			v, ok := lookup(value, dotted)
+			if !ok {
+				args = nil
+				break
+			}
+			// The builder or mixin the field is set on takes any items of
+			// arrays
+			parts := strings.Split(dotted, ".")
+			parent := b
+			if len(parts) > 1 {
+				mixin := append([]string{"mixin"}, parts[:len(parts)-1]...)
+				parent.expr = access(b.expr, mixin...)
+				parent.path = join(b.path, mixin...)
+			}
+			args = append(args, c.value(parent, parts[len(parts)-1], v, indent))
+		}
+		if args == nil {
+			continue
+		}
+		for _, dotted := range ctor.fields {
+			consumed[dotted] = true
+		}
+		return access(b.expr, ctor.fn) + "(" + strings.Join(args, ", ") + ")", true
+	}
+	return "", false
+}
+
+// value returns the jsonnet for the value of field f of the builder or mixin
+// b, building the items of arrays of objects with their type if the library
+// has one.
+func (c *converter) value(b builderRef, f string, v interface{}, indent string) string {
+	items, ok := objectArray(v)
+	itemPath := join(b.path, f+"Type")
+	if !ok || !c.has("object", itemPath) {
+		return jsonnetLiteral(v, indent)
+	}
+	singular := f
+	if strings.HasSuffix(f, "s") && !strings.HasSuffix(f, "ss") {
+		singular = strings.TrimSuffix(f, "s")
+	}
+	qualified := b.owner + strings.ToUpper(singular[:1]) + singular[1:]
+	names := []string{singular, qualified}
+	if b.item {
+		names = []string{qualified}
+	}
+	name := c.declare(itemPath, access(b.expr, f+"Type"), names...)
+	itemType := builderRef{expr: name, path: itemPath, owner: name, item: true}
+
+	inner := indent + "  "
+	var built []string
+	for _, item := range items {
+		consumed := map[string]bool{}
+		var terms []string
+		if c.style == styleConstructor {
+			if call, ok := c.construct(itemType, f, item, consumed, inner); ok {
+				terms = append(terms, call)
+			}
+		}
+		terms = append(terms, c.fields(itemType, true, item, "", consumed, inner)...)
+		if len(terms) == 0 {
+			terms = []string{"{}"}
+		}
+		built = append(built, strings.Join(terms, " +\n"+inner))
+	}
+	return "[\n" + inner + strings.Join(built, ",\n"+inner) + ",\n" + indent + "]"
+}
+
+// fields returns the terms setting the fields of value that aren't consumed
+// yet with the builder or mixin b: mixins for nested objects, setters where
+// the library has them, and literals for the rest. prefix is the dotted path
+// of value in the object being built.
+func (c *converter) fields(b builderRef, isType bool, value map[string]interface{}, prefix string, consumed map[string]bool, indent string) []string {
+	var terms []string
+	for _, f := range objectKeys(value) {
+		dotted := prefix + f
+		if consumed[dotted] {
+			continue
+		}
+		v := value[f]
+		mixin := b
+		if isType {
+			mixin.expr, mixin.path = access(b.expr, "mixin", f), join(b.path, "mixin", f)
+		} else {
+			mixin.expr, mixin.path = access(b.expr, f), join(b.path, f)
+		}
+
+		object, isObject := v.(map[string]interface{})
+		switch {
+		case isObject && c.has("object", mixin.path) && c.has("function", mixin.path, []string{"mixinInstance"}):
+			terms = append(terms, c.fields(mixin, false, object, dotted+".", consumed, indent)...)
+		case c.has("function", b.path, []string{f}):
+			terms = append(terms, access(b.expr, f)+"("+c.value(b, f, v, indent)+")")
+		case isType:
+			terms = append(terms, jsonnetLiteral(map[string]interface{}{f: v}, indent))
+		default:
+			terms = append(terms, access(b.expr, "mixinInstance")+"("+jsonnetLiteral(map[string]interface{}{f: v}, indent)+")")
+		}
+	}
+	return terms
+}
+
+// object returns the jsonnet for object in the given style, and the style
+// it ended up in: objects built without any builder are literals.
+func (c *converter) object(object map[string]interface{}, style int, indent string) (string, int) {
+	path := kindPath(object)
+	if style == styleLiteral || !c.has("object", path) {
+		return jsonnetLiteral(object, indent), styleLiteral
+	}
+	c.style = style
+	kind := object["kind"].(string)
+	name := c.declare(path, access("k", path...), path[2], path[2]+strings.Title(path[1]))
+	b := builderRef{expr: name, path: path, owner: name}
+
+	consumed := map[string]bool{"apiVersion": true, "kind": true}
+	var terms []string
+	if style == styleConstructor {
+		if call, ok := c.construct(b, kind, object, consumed, indent); ok {
+			terms = append(terms, call)
+		} else if c.hasFunction(0, path, []string{"new"}) {
+			terms = append(terms, access(name, "new")+"()")
+		}
+	}
+	if len(terms) == 0 {
+		terms = append(terms, jsonnetLiteral(map[string]interface{}{"apiVersion": object["apiVersion"], "kind": kind}, indent))
+	}
+	terms = append(terms, c.fields(b, true, object, "", consumed, indent)...)
+	code := strings.Join(terms, " +\n"+indent)
+	if !references(code, name) {
+		return code, styleLiteral
+	}
+	return code, style
+}
+
+// references reports whether code refers to the local with the given name.
+func references(code, name string) bool {
+	return regexp.MustCompile(`\b` + name + `\b`).MatchString(code)
+}
+
+// objectName returns a name for the local holding object, like
+// nginxDeployment for the Deployment named nginx.
+func objectName(object map[string]interface{}) string {
+	kind := object["kind"].(string)
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	for i := 1; i < len(words); i++ {
+		words[i] = strings.Title(words[i])
+	}
+	camel := strings.Join(words, "")
+	if camel == "" || !unicode.IsLetter(rune(camel[0])) {
+		return lowerCamel(kind) + camel
+	}
+	return camel + kind
+}
+
+// program returns the jsonnet building objects in the given styles, and the
+// styles they ended up in.
+func program(types map[string]libraryType, objects []map[string]interface{}, styles []int) (string, []int) {
+	c := newConverter(types)
+	built := make([]int, len(objects))
+	var body string
+	if len(objects) == 1 {
+		body, built[0] = c.object(objects[0], styles[0], "")
+		body += "\n"
+	} else {
+		var defs, names []string
+		for i, object := range objects {
+			name := c.unique(objectName(object))
+			var code string
+			code, built[i] = c.object(object, styles[i], "  ")
+			defs = append(defs, fmt.Sprintf("local %s =\n  %s;\n", name, code))
+			names = append(names, name)
+		}
+		body = strings.Join(defs, "\n") + "\n{\n  apiVersion: \"v1\",\n  kind: \"List\",\n  items: [\n    " +
+			strings.Join(names, ",\n    ") + ",\n  ],\n}\n"
+	}
+
+	// Builders of objects that fell back to literals go unused. Locals only
+	// refer to ones declared before them.
+	var decls []string
+	used := body
+	for i := len(c.locals) - 1; i >= 0; i-- {
+		if l := c.locals[i]; references(used, l.name) {
+			decls = append([]string{fmt.Sprintf("local %s = %s;", l.name, l.expr)}, decls...)
+			used += l.expr
+		}
+	}
+	if len(decls) == 0 {
+		return body, built
+	}
+	return "local k = import \"k.libsonnet\";\n" + strings.Join(decls, "\n") + "\n\n" + body, built
+}
+
+// jsonnetString quotes s as a jsonnet string. JSON escapes are valid
+// jsonnet, but HTML characters are left as they are.
+func jsonnetString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// jsonnetLiteral returns value, decoded from JSON, as a jsonnet literal. It's
+// written on one line if that's short, and otherwise with one field or item
+// per line at the given indentation.
+func jsonnetLiteral(value interface{}, indent string) string {
+	var open, close string
+	var parts, multiline []string
+	inner := indent + "  "
+	switch value := value.(type) {
+	case map[string]interface{}:
+		open, close = "{", "}"
+		for _, k := range objectKeys(value) {
+			parts = append(parts, formatKey(k)+": "+jsonnetLiteral(value[k], indent))
+			multiline = append(multiline, formatKey(k)+": "+jsonnetLiteral(value[k], inner))
+		}
+	case []interface{}:
+		open, close = "[", "]"
+		for _, v := range value {
+			parts = append(parts, jsonnetLiteral(v, indent))
+			multiline = append(multiline, jsonnetLiteral(v, inner))
+		}
+	case string:
+		return jsonnetString(value)
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+	line := open + strings.Join(parts, ", ") + close
+	if len(parts) == 0 || (len(line) <= 60 && !strings.Contains(line, "\n")) {
+		return line
+	}
+	return open + "\n" + inner + strings.Join(multiline, ",\n"+inner) + ",\n" + indent + close
+}
+
+// convertEndpoint evaluates the code converting objects, and the probe that
+// finds the builders it can use, with the library of the request.
+var convertEndpoint = jsonnetEndpoint{
+	name: "convert",
+	op:   runJsonnet,
+}
+
+// evaluateJSON evaluates code with the library and decodes its output into
+// v. If that fails, the result to respond with is returned instead.
+func evaluateJSON(r *http.Request, library, code string, v interface{}) *CachedResult {
+	result := convertEndpoint.evaluate(r.Context(), clientID(r), JsonnetRequest{Code: code, Library: library})
+	if result.Response.Error != nil {
+		return &result
+	}
+	output, err := yaml.YAMLToJSON([]byte(*result.Response.Output))
+	if err == nil {
+		err = json.Unmarshal(output, v)
+	}
+	if err != nil {
+		result := errorResult(http.StatusInternalServerError, err)
+		return &result
+	}
+	return nil
+}
+
+// builtObjects returns the objects the output of converted code holds, in
+// the order they were converted.
+func builtObjects(output interface{}, count int) []interface{} {
+	if count == 1 {
+		return []interface{}{output}
+	}
+	list, _ := output.(map[string]interface{})
+	items, _ := list["items"].([]interface{})
+	return items
+}
+
+// isCodeError reports whether result failed because the code was wrong,
+// rather than because of a limit or a problem running jsonnet.
+func isCodeError(result *CachedResult) bool {
+	return result.Response.Code == CodeRuntimeError || result.Response.Code == CodeParseError
+}
+
+// convertHandler converts the Kubernetes objects in a YAML stream to jsonnet
+// using the builders of a library. Each object is built with the
+// constructors and mixins the library has, and the code is evaluated to
+// check that it gives back the same objects. Objects for which it doesn't
+// are built with fewer builders, down to literals, until it does.
+func convertHandler(w http.ResponseWriter, r *http.Request) {
+	var req ConvertRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	objects, err := parseManifests(req.YAML)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	library := req.Library
+	if library == "" {
+		library = filepath.Base(config.ExtraImportPath)
+	}
+
+	paths := &libraryPaths{seen: map[string]bool{}}
+	for _, object := range objects {
+		path := kindPath(object)
+		paths.add(path)
+		paths.add(join(path, "new"))
+		paths.addConstructors(path, object["kind"].(string))
+		paths.addFields(path, true, object)
+	}
+	var found []libraryType
+	if failed := evaluateJSON(r, library, probeCode(paths.paths), &found); failed != nil {
+		convertEndpoint.write(w, r, *failed)
+		return
+	}
+	types := map[string]libraryType{}
+	for i, path := range paths.paths {
+		if i < len(found) {
+			types[pathKey(path)] = found[i]
+		}
+	}
+
+	styles := make([]int, len(objects))
+	for {
+		code, built := program(types, objects, styles)
+		var output interface{}
+		failed := evaluateJSON(r, library, code, &output)
+		if failed != nil && !isCodeError(failed) {
+			convertEndpoint.write(w, r, *failed)
+			return
+		}
+
+		var mismatched []int
+		if failed == nil {
+			results := builtObjects(output, len(objects))
+			for i, object := range objects {
+				if i >= len(results) || !reflect.DeepEqual(results[i], interface{}(object)) {
+					mismatched = append(mismatched, i)
+				}
+			}
+			if len(mismatched) == 0 {
+				writeJSON(w, convertResponse(code, library, objects, built))
+				return
+			}
+		} else {
+			// Find the objects whose builders failed by evaluating each on
+			// its own
+			for i, object := range objects {
+				if styles[i] == styleLiteral {
+					continue
+				}
+				single, _ := program(types, objects[i:i+1], styles[i:i+1])
+				var result interface{}
+				failed := evaluateJSON(r, library, single, &result)
+				if failed != nil && !isCodeError(failed) {
+					convertEndpoint.write(w, r, *failed)
+					return
+				}
+				if failed != nil || !reflect.DeepEqual(result, interface{}(object)) {
+					mismatched = append(mismatched, i)
+				}
+			}
+		}
+
+		// Literals always give back the same object, so one that doesn't
+		// means the conversion can't be checked
+		progress := len(mismatched) > 0
+		for _, i := range mismatched {
+			progress = progress && styles[i] < styleLiteral
+			styles[i]++
+		}
+		if !progress {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("Could not convert the objects to jsonnet that evaluates back to them"))
+			return
+		}
+	}
+}
+
+// convertResponse describes the objects converted into code in the given
+// styles.
+func convertResponse(code, library string, objects []map[string]interface{}, styles []int) ConvertResponse {
+	resp := ConvertResponse{Code: code, Library: library}
+	for i, object := range objects {
+		metadata, _ := object["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		resp.Objects = append(resp.Objects, ConvertedObject{
+			APIVersion: object["apiVersion"].(string),
+			Kind:       object["kind"].(string),
+			Name:       name,
+			Style:      styleNames[styles[i]],
+		})
+	}
+	return resp
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+// diffContext is the number of unchanged lines around each hunk of a
+// unified diff.
+const diffContext = 3
+
+// The statuses of an api.ObjectDiff.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// objectKey identifies a Kubernetes object across environments.
+type objectKey struct {
+	APIVersion, Kind, Namespace, Name string
+}
+
+func (k objectKey) String() string {
+	return strings.Join([]string{k.APIVersion, k.Kind, k.Namespace, k.Name}, "/")
+}
+
+// keyObjects maps the objects of each component to their keys.
+func keyObjects(components map[string][]interface{}) map[objectKey]interface{} {
+	objects := map[objectKey]interface{}{}
+	for _, list := range components {
+		for _, object := range list {
+			o, _ := object.(map[string]interface{})
+			metadata, _ := o["metadata"].(map[string]interface{})
+			key := objectKey{}
+			key.APIVersion, _ = o["apiVersion"].(string)
+			key.Kind, _ = o["kind"].(string)
+			key.Namespace, _ = metadata["namespace"].(string)
+			key.Name, _ = metadata["name"].(string)
+			objects[key] = object
+		}
+	}
+	return objects
+}
+
+// fieldPath returns the path of the field key of the object at path.
+func fieldPath(path, key string) string {
+	if identifierRegexp.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+// diffValues appends the fields that differ between a and b, found at path,
+// to changes.
+func diffValues(path string, a, b interface{}, changes []api.FieldChange) []api.FieldChange {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var keys []string
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			changes = diffValues(fieldPath(path, k), a[k], b[k], changes)
+		}
+		return changes
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(a) || i < len(b); i++ {
+			var x, y interface{}
+			if i < len(a) {
+				x = a[i]
+			}
+			if i < len(b) {
+				y = b[i]
+			}
+			changes = diffValues(path+"["+strconv.Itoa(i)+"]", x, y, changes)
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, api.FieldChange{Path: path, Value: a, OtherValue: b})
+	}
+	return changes
+}
+
+// diffOp is a line of a diff: kept (' '), removed ('-') or added ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// maxDiffLines is the most lines left to compare after the common prefix and
+// suffix of two texts that unifiedDiff finds the changes between. Texts that
+// differ in more lines are only reported as different.
+const maxDiffLines = 10000
+
+// diffLines finds a shortest edit from the lines a to the lines b, with
+// Myers' O(ND) algorithm in linear space. It reports false if a and b
+// differ in more than maxDiffLines lines.
+func diffLines(a, b []string) ([]diffOp, bool) {
+	start, end := commonEnds(a, b)
+	if len(a)-start-end+len(b)-start-end > maxDiffLines {
+		return nil, false
+	}
+	return appendDiff(nil, a, b), true
+}
+
+// commonEnds returns the number of lines a and b start with and end with
+// in common, without overlapping.
+func commonEnds(a, b []string) (prefix, suffix int) {
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// appendDiff appends a shortest edit from a to b to ops. Past their common
+// prefix and suffix, it splits the edit at a point that middleSnake finds to
+// be on it, and diffs the lines before and after that point.
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	prefix, suffix := commonEnds(a, b)
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+	default:
+		x, y := middleSnake(a, b)
+		ops = appendDiff(ops, a[:x], b[:y])
+		ops = appendDiff(ops, a[x:], b[y:])
+	}
+
+	for _, line := range common {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// middleSnake returns a point (x, y) on a shortest edit from a to b, which
+// are non-empty and differ in their first and last lines, other than its
+// start and end. It follows the furthest reaching paths from the start and
+// from the end at once, keeping one per diagonal, until they overlap.
+func middleSnake(a, b []string) (int, int) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward[offset+k] and backward[offset+k] are how far along a the
+	// paths on diagonal k reach, from the start and from the end
+	forward, backward := make([]int, 2*maxD+2), make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// With an odd delta, the paths from the start are the ones to reach the
+	// overlap first
+	odd := delta%2 != 0
+	// Diagonals that ran off the edges of the edit graph are skipped
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+	for d := 0; d < maxD; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || k != d && forward[i-1] < forward[i+1] {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			forward[i] = x
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(backward) && backward[j] != -1 && x >= n-backward[j] {
+					return x, y
+				}
+			}
+		}
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			i := offset + k
+			var x int
+			if k == -d || k != d && backward[i-1] < backward[i+1] {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x, y = x+1, y+1
+			}
+			backward[i] = x
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(forward) && forward[j] != -1 && forward[j] >= n-x {
+					return forward[j], forward[j] - (delta - k)
+				}
+			}
+		}
+	}
+	// Without a line in common, the edit removes all of a and adds all of b
+	return n, 0
+}
+
+// splitLines splits text into lines, without their line breaks.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedDiff returns a unified diff from the text a of the file from to the
+// text b of the file to, or "" if they're the same. Texts that differ in too
+// many lines to compare are only said to differ.
+func unifiedDiff(from, to, a, b string) string {
+	ops, ok := diffLines(splitLines(a), splitLines(b))
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ\n", from, to)
+	}
+
+	// The line numbers in a and b that each op starts at
+	lineA, lineB := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for k, op := range ops {
+		lineA[k+1], lineB[k+1] = lineA[k], lineB[k]
+		if op.kind != '+' {
+			lineA[k+1]++
+		}
+		if op.kind != '-' {
+			lineB[k+1]++
+		}
+	}
+
+	var out bytes.Buffer
+	for next := 0; next < len(ops); {
+		start := next
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// A hunk takes in the changes that are close enough for their
+		// context to touch
+		end := start
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			kept := end
+			for kept < len(ops) && ops[kept].kind == ' ' {
+				kept++
+			}
+			if kept == len(ops) || kept-end > 2*diffContext {
+				break
+			}
+			end = kept
+		}
+		if start -= diffContext; start < next {
+			start = next
+		}
+		if end += diffContext; end > len(ops) {
+			end = len(ops)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(lineA[start], lineA[end]-lineA[start]), hunkRange(lineB[start], lineB[end]-lineB[start]))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		next = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the lines of a hunk header, which counts lines from 1
+// except for empty ranges.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// diffEnvironments compares the objects rendered for two environments.
+func diffEnvironments(from, to string, objects, otherObjects map[objectKey]interface{}) (api.DiffResponse, error) {
+	var keys []objectKey
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	for key := range otherObjects {
+		if _, ok := objects[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.APIVersion != b.APIVersion {
+			return a.APIVersion < b.APIVersion
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	resp := api.DiffResponse{Objects: []api.ObjectDiff{}}
+	var diffs []string
+	for _, key := range keys {
+		object, ok := objects[key]
+		otherObject, otherOk := otherObjects[key]
+		if ok && otherOk && reflect.DeepEqual(object, otherObject) {
+			continue
+		}
+
+		d := api.ObjectDiff{
+			APIVersion: key.APIVersion,
+			Kind:       key.Kind,
+			Namespace:  key.Namespace,
+			Name:       key.Name,
+			Status:     diffChanged,
+		}
+		fromName, toName := from+"/"+key.String(), to+"/"+key.String()
+		var yamlA, yamlB []byte
+		var err error
+		if ok {
+			if yamlA, err = yaml.Marshal(object); err != nil {
+				return resp, err
+			}
+		} else {
+			d.Status, fromName = diffAdded, "/dev/null"
+		}
+		if otherOk {
+			if yamlB, err = yaml.Marshal(otherObject); err != nil {
+				return resp, err
+			}
+		} else {
+			d.Status, toName = diffRemoved, "/dev/null"
+		}
+		if d.Status == diffChanged {
+			d.Changes = diffValues("", object, otherObject, nil)
+		}
+		d.Diff = unifiedDiff(fromName, toName, string(yamlA), string(yamlB))
+
+		resp.Objects = append(resp.Objects, d)
+		diffs = append(diffs, d.Diff)
+	}
+	resp.Diff = strings.Join(diffs, "")
+	return resp, nil
+}
+
+// diffHandler renders an app for two environments and compares the objects,
+// the way `ks diff` does for two local environments.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	var req api.DiffRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	if req.Environment == "" || req.OtherEnvironment == "" {
+		writeError(w, http.StatusBadRequest, errors.New("Missing the environments to compare"))
+		return
+	}
+
+	var rendered []map[objectKey]interface{}
+	for _, env := range []string{req.Environment, req.OtherEnvironment} {
+		show := api.ShowRequest{
+			AppName:      req.AppName,
+			Components:   req.Components,
+			Environments: req.Environments,
+			Environment:  env,
+			K8sVersion:   req.K8sVersion,
+			Format:       showJSON,
+		}
+		if err := normalizeShowRequest(&show); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		components, failed := renderEnvironment(r, show)
+		if failed != nil {
+			showEndpoint.write(w, r, *failed)
+			return
+		}
+		rendered = append(rendered, keyObjects(components))
+	}
+
+	resp, err := diffEnvironments(req.Environment, req.OtherEnvironment, rendered[0], rendered[1])
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// These edit jsonnet object literals in place, so that changing a field of
+// a file keeps the comments and formatting of the rest of it. Only as much
+// of the syntax is understood as it takes to find the fields of an object.
+
+// identifierRegexp matches the field names that don't need quotes.
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// jsonnetKeywords can't be used as field names without quotes.
+var jsonnetKeywords = map[string]bool{
+	"assert": true, "else": true, "error": true, "false": true, "for": true,
+	"function": true, "if": true, "import": true, "importstr": true, "in": true,
+	"local": true, "null": true, "self": true, "super": true, "tailstrict": true,
+	"then": true, "true": true,
+}
+
+// token is a token of jsonnet code, located by offsets into the code.
+// Strings and identifiers are whole tokens, and any other character is a
+// token of its own.
+type token struct {
+	start, end int
+}
+
+// nextToken returns the token of code at or after i, skipping whitespace and
+// comments. At the end of the code, the token is empty and starts at
+// len(code).
+func nextToken(code string, i int) (token, error) {
+	for i < len(code) {
+		c := code[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(code[i:], "//"):
+			j := strings.IndexByte(code[i:], '\n')
+			if j < 0 {
+				return token{len(code), len(code)}, nil
+			}
+			i += j + 1
+		case strings.HasPrefix(code[i:], "/*"):
+			j := strings.Index(code[i+2:], "*/")
+			if j < 0 {
+				return token{}, fmt.Errorf("Unterminated comment at offset %d", i)
+			}
+			i += j + 4
+		case strings.HasPrefix(code[i:], "|||"):
+			j := strings.Index(code[i+3:], "|||")
+			if j < 0 {
+				return token{}, fmt.Errorf("Unterminated text block at offset %d", i)
+			}
+			return token{i, i + j + 6}, nil
+		case c == '@' && i+1 < len(code) && (code[i+1] == '"' || code[i+1] == '\''):
+			// Verbatim strings escape their quote by doubling it
+			for j := i + 2; j < len(code); j++ {
+				if code[j] == code[i+1] {
+					if j+1 < len(code) && code[j+1] == code[i+1] {
+						j++
+						continue
+					}
+					return token{i, j + 1}, nil
+				}
+			}
+			return token{}, fmt.Errorf("Unterminated string at offset %d", i)
+		case c == '"' || c == '\'':
+			for j := i + 1; j < len(code); j++ {
+				if code[j] == '\\' {
+					j++
+				} else if code[j] == c {
+					return token{i, j + 1}, nil
+				}
+			}
+			return token{}, fmt.Errorf("Unterminated string at offset %d", i)
+		case isIdentifierChar(c):
+			j := i
+			for j < len(code) && isIdentifierChar(code[j]) {
+				j++
+			}
+			return token{i, j}, nil
+		default:
+			return token{i, i + 1}, nil
+		}
+	}
+	return token{len(code), len(code)}, nil
+}
+
+func isIdentifierChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// objectField is a field of an object literal, located by offsets into the
+// code. Locals, asserts and computed fields have an empty key.
+type objectField struct {
+	key        string
+	start, end int
+	// valueStart is where the value after the colon starts, or -1
+	valueStart int
+	// next is the end of the comma after the field, or end without one
+	next int
+}
+
+// objectLiteral is an object literal, located by offsets into the code.
+type objectLiteral struct {
+	open, close int
+	fields      []objectField
+}
+
+// field returns the field of o named key, or nil.
+func (o *objectLiteral) field(key string) *objectField {
+	for i := range o.fields {
+		if o.fields[i].key == key {
+			return &o.fields[i]
+		}
+	}
+	return nil
+}
+
+// fieldKey returns the name of a field starting with the token text, or ""
+// if it isn't a plain field.
+func fieldKey(text string) string {
+	switch {
+	case identifierRegexp.MatchString(text):
+		if text == "local" || text == "assert" {
+			return ""
+		}
+		return text
+	case strings.HasPrefix(text, `"`):
+		key, _ := strconv.Unquote(text)
+		return key
+	case strings.HasPrefix(text, "'"):
+		inner := strings.Replace(text[1:len(text)-1], `\'`, `'`, -1)
+		key, _ := strconv.Unquote(`"` + strings.Replace(inner, `"`, `\"`, -1) + `"`)
+		return key
+	}
+	return ""
+}
+
+// formatKey returns key as a field name, quoting it if it has to be.
+func formatKey(key string) string {
+	if identifierRegexp.MatchString(key) && !jsonnetKeywords[key] {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+// parseObject parses the object literal whose opening brace is at offset
+// open in code.
+func parseObject(code string, open int) (*objectLiteral, error) {
+	obj := &objectLiteral{open: open}
+	i := open + 1
+	for {
+		t, err := nextToken(code, i)
+		if err != nil {
+			return nil, err
+		}
+		if t.start == len(code) {
+			return nil, fmt.Errorf("Unterminated object at offset %d", open)
+		}
+		if code[t.start] == '}' {
+			obj.close = t.start
+			return obj, nil
+		}
+
+		// A field runs up to the next comma or closing brace outside of
+		// any brackets
+		field := objectField{key: fieldKey(code[t.start:t.end]), start: t.start, valueStart: -1}
+		depth, colon := 0, false
+		for {
+			if t.start == len(code) {
+				return nil, fmt.Errorf("Unterminated object at offset %d", open)
+			}
+			text := code[t.start:t.end]
+			if depth == 0 && (text == "," || text == "}") {
+				field.next = field.end
+				if text == "," {
+					field.next = t.end
+				}
+				i = field.next
+				break
+			}
+			if colon && field.valueStart < 0 && text != ":" {
+				field.valueStart = t.start
+			}
+			switch text {
+			case "{", "[", "(":
+				depth++
+			case "}", "]", ")":
+				depth--
+			case ":":
+				colon = colon || depth == 0
+			}
+			field.end = t.end
+			if t, err = nextToken(code, t.end); err != nil {
+				return nil, err
+			}
+		}
+		obj.fields = append(obj.fields, field)
+	}
+}
+
+// lastTopLevelObject parses the last object literal in code that isn't
+// inside any brackets: the object a params file evaluates to, or the
+// overrides an environment adds to the global params.
+func lastTopLevelObject(code string) (*objectLiteral, error) {
+	depth, open := 0, -1
+	for i := 0; i < len(code); {
+		t, err := nextToken(code, i)
+		if err != nil {
+			return nil, err
+		}
+		switch code[t.start:t.end] {
+		case "{":
+			if depth == 0 {
+				open = t.start
+			}
+			depth++
+		case "[", "(":
+			depth++
+		case "}", "]", ")":
+			depth--
+		}
+		i = t.end
+	}
+	if open < 0 {
+		return nil, fmt.Errorf("No object found")
+	}
+	return parseObject(code, open)
+}
+
+// insertField adds the field text after the last field of obj, on a line of
+// its own if the object spans several lines.
+func insertField(code string, obj *objectLiteral, text string) string {
+	comma := -1
+	if n := len(obj.fields); n > 0 && obj.fields[n-1].next == obj.fields[n-1].end {
+		comma = obj.fields[n-1].end
+	}
+
+	lineStart := strings.LastIndex(code[:obj.close], "\n") + 1
+	if lineStart > obj.open && strings.TrimSpace(code[lineStart:obj.close]) == "" {
+		// Line the field up with the last one, or indent it past the brace
+		indent := code[lineStart:obj.close] + "  "
+		if n := len(obj.fields); n > 0 {
+			start := obj.fields[n-1].start
+			fieldLine := strings.LastIndex(code[:start], "\n") + 1
+			if strings.TrimSpace(code[fieldLine:start]) == "" {
+				indent = code[fieldLine:start]
+			}
+		}
+		code = code[:lineStart] + indent + text + ",\n" + code[lineStart:]
+	} else {
+		end := obj.close
+		for end > obj.open+1 && code[end-1] == ' ' {
+			end--
+		}
+		code = code[:end] + " " + text + " " + code[obj.close:]
+	}
+
+	if comma >= 0 {
+		code = code[:comma] + "," + code[comma:]
+	}
+	return code
+}
+
+// removeField deletes f from code, along with its line if nothing else is on
+// it.
+func removeField(code string, f *objectField) string {
+	lineStart := strings.LastIndex(code[:f.start], "\n") + 1
+	lineEnd := strings.IndexByte(code[f.next:], '\n')
+	if strings.TrimSpace(code[lineStart:f.start]) == "" && lineEnd >= 0 &&
+		strings.TrimSpace(code[f.next:f.next+lineEnd]) == "" {
+		return code[:lineStart] + code[f.next+lineEnd+1:]
+	}
+	end := f.next
+	for end < len(code) && code[end] == ' ' {
+		end++
+	}
+	return code[:f.start] + code[end:]
+}
+
+// childObject returns the object literal that is the value of the field of
+// obj named key, adding the field with an empty object if it's missing. sep
+// is what separates the name of an added field from its value.
+func childObject(code string, obj *objectLiteral, key, sep string) (string, *objectLiteral, error) {
+	if obj.field(key) == nil {
+		code = insertField(code, obj, formatKey(key)+sep+" {}")
+		var err error
+		if obj, err = parseObject(code, obj.open); err != nil {
+			return code, nil, err
+		}
+	}
+	f := obj.field(key)
+	if f.valueStart < 0 || code[f.valueStart] != '{' {
+		return code, nil, fmt.Errorf("The value of %s isn't an object literal", formatKey(key))
+	}
+	child, err := parseObject(code, f.valueStart)
+	return code, child, err
+}
+
+// setField sets the field of obj named key to the jsonnet value, keeping the
+// way an existing field is written.
+func setField(code string, obj *objectLiteral, key, value string) string {
+	if f := obj.field(key); f != nil && f.valueStart >= 0 {
+		return code[:f.valueStart] + value + code[f.end:]
+	}
+	return insertField(code, obj, formatKey(key)+": "+value)
+}
+
+// renameField changes the name of f to key, keeping the way its value is
+// written.
+func renameField(code string, f *objectField, key string) (string, error) {
+	t, err := nextToken(code, f.start)
+	if err != nil {
+		return "", err
+	}
+	return code[:t.start] + formatKey(key) + code[t.end:], nil
+}
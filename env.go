@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+var errEnvExists = errors.New("Environment already exists")
+
+// clusterScopedKinds are the kinds of objects that don't live in a
+// namespace, so rendering doesn't give them the environment's.
+var clusterScopedKinds = map[string]bool{
+	"APIService":                     true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"Namespace":                      true,
+	"Node":                           true,
+	"PersistentVolume":               true,
+	"PodSecurityPolicy":              true,
+	"StorageClass":                   true,
+	"ThirdPartyResource":             true,
+	"ValidatingWebhookConfiguration": true,
+	"MutatingWebhookConfiguration":   true,
+}
+
+// environmentDir returns the directory of the named environment in the
+// environments tree of an app.
+func environmentDir(envs map[string]interface{}, name string) (map[string]interface{}, error) {
+	dir, ok := envs[name].(map[string]interface{})
+	if !ok {
+		return nil, errNotFound
+	}
+	if _, ok := dir["spec.json"].(string); !ok {
+		return nil, errNotFound
+	}
+	return dir, nil
+}
+
+// readEnvSpec parses the spec.json of an environment directory.
+func readEnvSpec(name string, dir map[string]interface{}) (envSpec, error) {
+	var spec envSpec
+	contents, _ := dir["spec.json"].(string)
+	if err := json.Unmarshal([]byte(contents), &spec); err != nil {
+		return spec, badInputError(fmt.Sprintf("Invalid spec.json in environment %q: %v", name, err))
+	}
+	return spec, nil
+}
+
+// writeEnvSpec replaces the spec.json of an environment directory.
+func writeEnvSpec(dir map[string]interface{}, spec envSpec) {
+	specJSON, _ := json.MarshalIndent(spec, "", "  ")
+	dir["spec.json"] = string(specJSON)
+}
+
+// listEnvironments describes the environments in the environments tree of
+// an app: the directories with a spec.json.
+func listEnvironments(envs map[string]interface{}) ([]api.Environment, error) {
+	var names []string
+	for name := range envs {
+		if _, err := environmentDir(envs, name); err == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	list := []api.Environment{}
+	for _, name := range names {
+		spec, err := readEnvSpec(name, envs[name].(map[string]interface{}))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, api.Environment{Name: name, Server: spec.Server, Namespace: spec.Namespace})
+	}
+	return list, nil
+}
+
+// addEnvironment adds the environment described by req to envs, the way
+// `ks env add` does.
+func addEnvironment(envs map[string]interface{}, req api.EnvRequest) error {
+	if req.Namespace == "" {
+		req.Namespace = defaultNamespace
+	}
+	if req.K8sVersion == "" {
+		req.K8sVersion = defaultK8sVersion
+	}
+	if err := validateEnvironmentName(req.Name); err != nil {
+		return badInputError(err.Error())
+	}
+	if err := validateServer(req.Server); err != nil {
+		return badInputError(err.Error())
+	}
+	if _, ok := envs[req.Name]; ok {
+		return errEnvExists
+	}
+
+	library, err := k8sLibrary(req.K8sVersion)
+	if err != nil {
+		return badInputError(err.Error())
+	}
+	env, err := newEnvironment(envSpec{Server: req.Server, Namespace: req.Namespace}, library, req.K8sVersion)
+	if err != nil {
+		return err
+	}
+	envs[req.Name] = env
+	return nil
+}
+
+// updateEnvironment changes the server, namespace or name of an environment
+// in envs, the way `ks env set` does.
+func updateEnvironment(envs map[string]interface{}, req api.EnvRequest) error {
+	if req.Server == "" && req.Namespace == "" && req.NewName == "" {
+		return badInputError("Nothing to update - Expected a server, namespace or newName")
+	}
+	dir, err := environmentDir(envs, req.Name)
+	if err != nil {
+		return err
+	}
+	spec, err := readEnvSpec(req.Name, dir)
+	if err != nil {
+		return err
+	}
+
+	if req.Server != "" {
+		if err := validateServer(req.Server); err != nil {
+			return badInputError(err.Error())
+		}
+		spec.Server = req.Server
+	}
+	if req.Namespace != "" {
+		spec.Namespace = req.Namespace
+	}
+	if req.NewName != "" && req.NewName != req.Name {
+		if err := validateEnvironmentName(req.NewName); err != nil {
+			return badInputError(err.Error())
+		}
+		if _, ok := envs[req.NewName]; ok {
+			return errEnvExists
+		}
+		delete(envs, req.Name)
+		envs[req.NewName] = dir
+	}
+	writeEnvSpec(dir, spec)
+	return nil
+}
+
+// removeEnvironment deletes an environment from envs, the way `ks env rm`
+// does.
+func removeEnvironment(envs map[string]interface{}, req api.EnvRequest) error {
+	if _, err := environmentDir(envs, req.Name); err != nil {
+		return err
+	}
+	delete(envs, req.Name)
+	return nil
+}
+
+// customClusterScopedKinds returns the group and kind, joined by a slash, of
+// the custom resources that the CustomResourceDefinitions among objects
+// declare with the Cluster scope, for injectNamespace.
+func customClusterScopedKinds(objects []interface{}) map[string]bool {
+	kinds := map[string]bool{}
+	for _, object := range objects {
+		object, _ := object.(map[string]interface{})
+		if kind, _ := object["kind"].(string); kind != "CustomResourceDefinition" {
+			continue
+		}
+		spec, _ := object["spec"].(map[string]interface{})
+		names, _ := spec["names"].(map[string]interface{})
+		group, _ := spec["group"].(string)
+		kind, _ := names["kind"].(string)
+		if scope, _ := spec["scope"].(string); scope == "Cluster" && kind != "" {
+			kinds[group+"/"+kind] = true
+		}
+	}
+	return kinds
+}
+
+// injectNamespace puts the namespaced objects without a namespace of their
+// own in the given namespace, as ks does when it deploys an environment.
+// Besides the built-in cluster-scoped kinds, the custom ones found by
+// customClusterScopedKinds are left alone.
+func injectNamespace(objects []interface{}, namespace string, custom map[string]bool) {
+	if namespace == "" {
+		return
+	}
+	for _, object := range objects {
+		object := object.(map[string]interface{})
+		kind, _ := object["kind"].(string)
+		apiVersion, _ := object["apiVersion"].(string)
+		if clusterScopedKinds[kind] || custom[apiGroup(apiVersion)+"/"+kind] {
+			continue
+		}
+		metadata, ok := object["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+			object["metadata"] = metadata
+		}
+		if ns, _ := metadata["namespace"].(string); ns == "" {
+			metadata["namespace"] = namespace
+		}
+	}
+}
+
+// envStatus returns the HTTP status to send an error from an environment
+// operation with.
+func envStatus(err error) int {
+	if _, ok := err.(badInputError); ok {
+		return http.StatusBadRequest
+	}
+	switch err {
+	case errNotFound:
+		return http.StatusNotFound
+	case errEnvExists:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// envHandler serves the `ks env` operation op, which changes the
+// environments of the app in the request and responds with the changed app.
+func envHandler(op func(map[string]interface{}, api.EnvRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readEnvRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := op(req.Environments, req); err != nil {
+			if err == errNotFound {
+				err = fmt.Errorf("No environment %q in the app", req.Name)
+			}
+			writeError(w, envStatus(err), err)
+			return
+		}
+		writeJSON(w, api.InitResponse{
+			AppName:      req.AppName,
+			AppYAML:      req.AppYAML,
+			Components:   req.Components,
+			Environments: req.Environments,
+		})
+	}
+}
+
+// envListHandler lists the environments of the app in the request.
+func envListHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := readEnvRequest(w, r)
+	if !ok {
+		return
+	}
+	list, err := listEnvironments(req.Environments)
+	if err != nil {
+		writeError(w, envStatus(err), err)
+		return
+	}
+	writeJSON(w, api.EnvListResponse{Environments: list})
+}
+
+// workspaceEnvHandler serves the `ks env` operation op on the app of a
+// workspace, keeping the changed app as a new revision.
+func workspaceEnvHandler(verb string, op func(map[string]interface{}, api.EnvRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req WorkspaceEnvRequest
+		if !readAppRequest(w, r, &req) {
+			return
+		}
+		message := fmt.Sprintf("%s environment %s", verb, req.Name)
+		ws, err := workspaces.update(workspaceID(r), message, func(app *api.InitResponse) error {
+			if app.Environments == nil {
+				app.Environments = map[string]interface{}{}
+			}
+			return op(app.Environments, api.EnvRequest{
+				Name:       req.Name,
+				Server:     req.Server,
+				Namespace:  req.Namespace,
+				K8sVersion: req.K8sVersion,
+				NewName:    req.NewName,
+			})
+		})
+		if err == errNotFound {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No environment %q in the workspace", req.Name))
+			return
+		} else if err != nil {
+			writeError(w, workspaceStatus(err), err)
+			return
+		}
+		writeJSON(w, ws)
+	}
+}
+
+// workspaceEnvListHandler lists the environments of a workspace.
+func workspaceEnvListHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	app, err := workspaces.revision(workspaceID(r), 0)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	list, err := listEnvironments(app.Environments)
+	if err != nil {
+		writeError(w, envStatus(err), err)
+		return
+	}
+	writeJSON(w, api.EnvListResponse{Environments: list})
+}
+
+// readEnvRequest decodes the api.EnvRequest in the body of r, answering the
+// request itself if it can't.
+func readEnvRequest(w http.ResponseWriter, r *http.Request) (api.EnvRequest, bool) {
+	var req api.EnvRequest
+	if !readAppRequest(w, r, &req) {
+		return req, false
+	}
+	if req.Environments == nil {
+		req.Environments = map[string]interface{}{}
+	}
+	return req, true
+}
+
+// readAppRequest decodes a request carrying an app from the body of r into
+// req, answering the request itself if it can't. Apps are limited to
+// MaxAppBytes rather than MaxContentLength.
+func readAppRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxAppBytes)
+	defer r.Body.Close()
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return false
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		abuse.record(client, abuseTooLarge)
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("Request too large - Apps must be smaller than %v bytes", config.MaxAppBytes))
+		return false
+	}
+	if err := json.Unmarshal(body, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+// ErrorCode is a stable, machine-readable classification of a failed
+// request, so clients don't need to match on error messages.
+type ErrorCode string
+
+const (
+	CodeParseError       ErrorCode = "PARSE_ERROR"
+	CodeRuntimeError     ErrorCode = "RUNTIME_ERROR"
+	CodeTimeout          ErrorCode = "TIMEOUT"
+	CodeTooLarge         ErrorCode = "TOO_LARGE"
+	CodeRateLimited      ErrorCode = "RATE_LIMITED"
+	CodeBadRequest       ErrorCode = "BAD_REQUEST"
+	CodeUnauthorized     ErrorCode = "UNAUTHORIZED"
+	CodeForbidden        ErrorCode = "FORBIDDEN"
+	CodeNotFound         ErrorCode = "NOT_FOUND"
+	CodeConflict         ErrorCode = "CONFLICT"
+	CodeMethodNotAllowed ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeInternal         ErrorCode = "INTERNAL"
+)
+
+// enum lists the possible values, for the OpenAPI spec.
+func (ErrorCode) enum() []string {
+	return []string{
+		string(CodeParseError), string(CodeRuntimeError), string(CodeTimeout),
+		string(CodeTooLarge), string(CodeRateLimited), string(CodeBadRequest),
+		string(CodeUnauthorized), string(CodeForbidden), string(CodeNotFound),
+		string(CodeConflict), string(CodeMethodNotAllowed),
+		string(CodeInternal),
+	}
+}
+
+// retryable reports whether the same request might succeed if it's sent
+// again later. Errors caused by the code itself never go away on a retry.
+func (c ErrorCode) retryable() bool {
+	return c == CodeRateLimited || c == CodeInternal
+}
+
+// statusErrorCodes classifies errors that are fully described by the HTTP
+// status they're sent with.
+var statusErrorCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusTooManyRequests:       CodeRateLimited,
+}
+
+// statusErrorCode returns the ErrorCode for an error sent with status.
+func statusErrorCode(status int) ErrorCode {
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	return CodeInternal
+}
+
+// badInputError is an error in the input of an op other than in the jsonnet
+// code itself, such as an app with invalid file names.
+type badInputError string
+
+func (e badInputError) Error() string {
+	return string(e)
+}
+
+// jsonnetErrorCode classifies an error from running jsonnet, returning the
+// code and the HTTP status to send it with. output is what jsonnet printed,
+// which tells static errors from runtime ones.
+func jsonnetErrorCode(err error, output string) (ErrorCode, int) {
+	if err == errTimeout {
+		return CodeTimeout, http.StatusBadRequest
+	}
+	if _, ok := err.(badInputError); ok {
+		return CodeBadRequest, http.StatusBadRequest
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		if strings.Contains(output, "STATIC ERROR") {
+			return CodeParseError, http.StatusBadRequest
+		}
+		return CodeRuntimeError, http.StatusBadRequest
+	}
+	// jsonnet couldn't be started, or its output couldn't be converted
+	return CodeInternal, http.StatusInternalServerError
+}
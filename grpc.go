@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/heptio/ksonnet-playground/rpc"
+)
+
+// grpcStatus is a gRPC status code, sent in the grpc-status trailer.
+type grpcStatus int
+
+// The gRPC status codes the playground answers with.
+const (
+	grpcOK                grpcStatus = 0
+	grpcInvalidArgument   grpcStatus = 3
+	grpcDeadlineExceeded  grpcStatus = 4
+	grpcPermissionDenied  grpcStatus = 7
+	grpcResourceExhausted grpcStatus = 8
+	grpcUnimplemented     grpcStatus = 12
+	grpcInternal          grpcStatus = 13
+)
+
+// grpcMaxMessageSize is the largest request message accepted, which is also
+// gRPC's default limit.
+const grpcMaxMessageSize = 4 << 20
+
+// grpcError is a failed RPC. Evaluation errors are returned in the
+// JsonnetResponse, as over HTTP; a grpcError means the call itself failed.
+type grpcError struct {
+	status  grpcStatus
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// grpcStream writes response messages for a call.
+type grpcStream struct {
+	ctx context.Context
+	w   http.ResponseWriter
+}
+
+// errGRPCDeadline fails a call that ran past its grpc-timeout.
+var errGRPCDeadline = &grpcError{grpcDeadlineExceeded, "Deadline exceeded"}
+
+// send writes one length-prefixed message and flushes it to the client,
+// unless the call's deadline has passed.
+func (s grpcStream) send(m proto.Message) error {
+	if s.ctx.Err() == context.DeadlineExceeded {
+		return errGRPCDeadline
+	}
+	bytes, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(bytes)))
+	if _, err := s.w.Write(append(prefix[:], bytes...)); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// grpcMethod handles one RPC: it decodes a request message from body and
+// sends the response messages to stream.
+type grpcMethod func(ctx context.Context, client string, body []byte, stream grpcStream) error
+
+// grpcMethods maps the method names of rpc.Service to their handlers.
+var grpcMethods = map[string]grpcMethod{
+	"Evaluate":      unaryRPC(evalEndpoint),
+	"Format":        unaryRPC(formatEndpoint),
+	"Check":         unaryRPC(checkEndpoint),
+	"BatchEvaluate": batchEvaluateRPC,
+}
+
+// rpcEvaluate runs e for a request message, applying the same limits as the
+// HTTP API.
+func rpcEvaluate(ctx context.Context, e jsonnetEndpoint, client string, req *rpc.JsonnetRequest) *rpc.JsonnetResponse {
+	if int64(len(req.Code)) > config.MaxContentLength {
+		abuse.record(client, abuseTooLarge)
+		return rpcResponse(errorResult(http.StatusRequestEntityTooLarge, errTooLarge()))
+	}
+	return rpcResponse(e.evaluate(ctx, client, jsonnetRequest(req)))
+}
+
+// jsonnetRequest converts a request message into the JsonnetRequest of the
+// HTTP API.
+func jsonnetRequest(req *rpc.JsonnetRequest) JsonnetRequest {
+	return JsonnetRequest{
+		Code:       req.Code,
+		Validate:   req.Validate,
+		K8sVersion: req.K8SVersion,
+		Order:      req.Order,
+		Library:    req.Library,
+	}
+}
+
+// rpcResponse converts a result into its response message.
+func rpcResponse(result CachedResult) *rpc.JsonnetResponse {
+	res := result.Response
+	out := &rpc.JsonnetResponse{Code: string(res.Code), Retryable: res.Retryable}
+	if res.Output != nil {
+		out.Output = *res.Output
+	}
+	if res.Error != nil {
+		out.Error = *res.Error
+	}
+	for _, log := range res.Logs {
+		out.Logs = append(out.Logs, &rpc.LogLine{
+			Kind:    string(log.Kind),
+			Message: log.Message,
+			File:    log.File,
+			Line:    int32(log.Line),
+			Column:  int32(log.Column),
+		})
+	}
+	for _, diag := range res.Diagnostics {
+		out.Diagnostics = append(out.Diagnostics, &rpc.Diagnostic{Path: diag.Path, Message: diag.Message})
+	}
+	for _, o := range res.Order {
+		out.Order = append(out.Order, &rpc.OrderedObject{
+			ApiVersion: o.APIVersion,
+			Kind:       o.Kind,
+			Namespace:  o.Namespace,
+			Name:       o.Name,
+			Reason:     o.Reason,
+		})
+	}
+	return out
+}
+
+// unaryRPC answers a JsonnetRequest with a single JsonnetResponse from e.
+func unaryRPC(e jsonnetEndpoint) grpcMethod {
+	return func(ctx context.Context, client string, body []byte, stream grpcStream) error {
+		var req rpc.JsonnetRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+		return stream.send(rpcEvaluate(ctx, e, client, &req))
+	}
+}
+
+// batchEvaluateRPC evaluates the requests of a BatchRequest like the HTTP
+// batch endpoint does, streaming back each result as soon as it's ready.
+func batchEvaluateRPC(ctx context.Context, client string, body []byte, stream grpcStream) error {
+	var req rpc.BatchRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		return &grpcError{grpcInvalidArgument, err.Error()}
+	}
+	if err := checkBatchSize(len(req.Requests)); err != nil {
+		return &grpcError{grpcInvalidArgument, err.Error()}
+	}
+
+	reqs := make([]JsonnetRequest, len(req.Requests))
+	for i, item := range req.Requests {
+		if item != nil {
+			reqs[i] = jsonnetRequest(item)
+		}
+	}
+	return evaluateBatch(ctx, client, reqs, func(i int, result CachedResult) error {
+		return stream.send(&rpc.BatchItem{Index: int32(i), Response: rpcResponse(result)})
+	})
+}
+
+// grpcHandler serves rpc.Service over HTTP/2 using the gRPC wire protocol.
+// Only uncompressed messages are supported, which is what clients send
+// unless they're configured otherwise.
+func grpcHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/"+rpc.Service+"/")
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost ||
+		!strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests must be HTTP/2 POSTs of application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+
+	err := callRPC(w, r, name)
+	status, message := grpcOK, ""
+	if err != nil {
+		status, message = grpcInternal, err.Error()
+		if gerr, ok := err.(*grpcError); ok {
+			status = gerr.status
+		}
+		log.Printf("gRPC call to %s from client %s failed: %v", r.URL.Path, clientID(r), err)
+	}
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(status)))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// callRPC reads the request message and runs the named method.
+func callRPC(w http.ResponseWriter, r *http.Request, name string) error {
+	method, ok := grpcMethods[name]
+	if !ok {
+		return &grpcError{grpcUnimplemented, fmt.Sprintf("Unknown method %q", r.URL.Path)}
+	}
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		return &grpcError{grpcPermissionDenied, err.Error()}
+	}
+
+	var prefix [5]byte
+	if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+		return &grpcError{grpcInvalidArgument, "Missing request message"}
+	}
+	if prefix[0] != 0 {
+		return &grpcError{grpcUnimplemented, "Compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessageSize {
+		abuse.record(client, abuseTooLarge)
+		return &grpcError{grpcResourceExhausted, fmt.Sprintf("Request message larger than %v bytes", grpcMaxMessageSize)}
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r.Body, body); err != nil {
+		return &grpcError{grpcInvalidArgument, "Truncated request message"}
+	}
+
+	ctx := r.Context()
+	if header := r.Header.Get("Grpc-Timeout"); header != "" {
+		timeout, err := parseGRPCTimeout(header)
+		if err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := method(ctx, client, body, grpcStream{ctx, w})
+	if ctx.Err() == context.DeadlineExceeded {
+		return errGRPCDeadline
+	}
+	return err
+}
+
+// grpcTimeoutUnits maps the units of the grpc-timeout header to durations.
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour,
+	'M': time.Minute,
+	'S': time.Second,
+	'm': time.Millisecond,
+	'u': time.Microsecond,
+	'n': time.Nanosecond,
+}
+
+// parseGRPCTimeout parses a grpc-timeout header, which is at most 8 digits
+// followed by a unit.
+func parseGRPCTimeout(header string) (time.Duration, error) {
+	n := len(header) - 1
+	unit, ok := grpcTimeoutUnits[header[n]]
+	if !ok || n < 1 || n > 8 {
+		return 0, fmt.Errorf("Invalid grpc-timeout %q", header)
+	}
+	value, err := strconv.ParseInt(header[:n], 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Invalid grpc-timeout %q", header)
+	}
+	return time.Duration(value) * unit, nil
+}
+
+// newGRPCServer builds the server for the gRPC service, whose calls count
+// towards the same request metrics as the HTTP API. It has to be served over
+// TLS, which is how net/http negotiates HTTP/2, so without --grpc-tls-cert
+// and --grpc-tls-key it uses a self-signed certificate.
+func newGRPCServer() (*http.Server, error) {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.GRPCPort),
+		Handler: instrument(http.HandlerFunc(grpcHandler)),
+	}
+	if config.GRPCTLSCert == "" && config.GRPCTLSKey == "" {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	} else if config.GRPCTLSCert == "" || config.GRPCTLSKey == "" {
+		return nil, fmt.Errorf("--grpc-tls-cert and --grpc-tls-key must be set together")
+	}
+	return server, nil
+}
+
+// selfSignedCertificate generates a certificate for the gRPC server that
+// lasts as long as the process. Clients have to skip verifying it.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"ksonnet-playground"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	jobs           *jobStore
+	errJobNotFound = errors.New("No such job - it may have expired")
+	errTooManyJobs = errors.New("Too many jobs running, please try again")
+	errOwnJobs     = errors.New("Too many of your jobs running, please wait for one to finish")
+	errBadAPIKey   = errors.New("Unknown API key")
+)
+
+// JobStatus is the state of a Job.
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// enum lists the possible values, for the OpenAPI spec.
+func (JobStatus) enum() []string {
+	return []string{string(JobRunning), string(JobDone), string(JobCancelled)}
+}
+
+// Job is an evaluation running in the background, for code that may take
+// longer than a request should stay open. Result is set once the job is
+// done, and the job is forgotten at Expires.
+type Job struct {
+	ID             string           `json:"id"`
+	Status         JobStatus        `json:"status"`
+	TimeoutSeconds int              `json:"timeoutSeconds"`
+	Created        time.Time        `json:"created"`
+	Expires        *time.Time       `json:"expires"`
+	Result         *JsonnetResponse `json:"result"`
+}
+
+// runningJob is a Job along with what's needed to cancel it, and who it
+// counts against while it runs.
+type runningJob struct {
+	Job
+	cancel context.CancelFunc
+	owner  string
+}
+
+// jobStore holds the jobs that are running or whose outcome is still kept.
+// Job IDs are random and unguessable, so knowing one is what allows a client
+// to read or cancel the job.
+type jobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*runningJob
+	running int
+	// byOwner counts the running jobs of each owner
+	byOwner map[string]int
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: map[string]*runningJob{}, byOwner: map[string]int{}}
+}
+
+// submit starts evaluating req with e on behalf of client, returning the new
+// job, or an error if too many jobs are already running, overall or of
+// owner's.
+func (s *jobStore) submit(e jsonnetEndpoint, client, owner string, req JsonnetRequest) (Job, error) {
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return Job{}, err
+	}
+	timeout := e.timeout
+	if timeout == 0 {
+		timeout = config.JsonnetRunTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running >= config.MaxJobs {
+		return Job{}, errTooManyJobs
+	}
+	if s.byOwner[owner] >= config.MaxJobsPerClient {
+		return Job{}, errOwnJobs
+	}
+
+	// Jobs outlive the request that submitted them, so they only stop when
+	// they're done or cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &runningJob{
+		Job: Job{
+			ID:             hex.EncodeToString(idBytes[:]),
+			Status:         JobRunning,
+			TimeoutSeconds: int(timeout / time.Second),
+			Created:        time.Now(),
+		},
+		cancel: cancel,
+		owner:  owner,
+	}
+	s.jobs[job.ID] = job
+	s.running++
+	s.byOwner[owner]++
+	p8sRunningJobs.Inc()
+
+	go func() {
+		result := e.evaluate(ctx, client, req)
+		s.finish(job, JobDone, &result.Response)
+	}()
+	return job.Job, nil
+}
+
+// finish moves job out of the running state, unless it already was, and
+// schedules it to be forgotten once the retention period is over.
+func (s *jobStore) finish(job *runningJob, status JobStatus, result *JsonnetResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job.Status != JobRunning {
+		return
+	}
+	job.cancel()
+	s.running--
+	if s.byOwner[job.owner]--; s.byOwner[job.owner] == 0 {
+		delete(s.byOwner, job.owner)
+	}
+	p8sRunningJobs.Dec()
+
+	expires := time.Now().Add(config.JobRetention)
+	job.Status, job.Result, job.Expires = status, result, &expires
+	time.AfterFunc(config.JobRetention, func() {
+		s.mu.Lock()
+		delete(s.jobs, job.ID)
+		s.mu.Unlock()
+	})
+}
+
+// get returns the job with the given ID.
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return job.Job, true
+}
+
+// cancel stops the job with the given ID if it's still running, returning
+// its final state.
+func (s *jobStore) cancel(id string) (Job, bool) {
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return Job{}, false
+	}
+	s.finish(job, JobCancelled, nil)
+	return s.get(id)
+}
+
+// jobEndpoint picks the endpoint that runs jobs for r: requests with one of
+// the job API keys as a bearer token get the job run timeout, and others the
+// usual one.
+func jobEndpoint(r *http.Request) (jsonnetEndpoint, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return evalEndpoint, nil
+	}
+	key := strings.TrimPrefix(auth, "Bearer ")
+	for _, k := range config.JobAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return longEvalEndpoint, nil
+		}
+	}
+	return jsonnetEndpoint{}, errBadAPIKey
+}
+
+// jobOwner is who the job r submits counts against for the per-client limit
+// on running jobs: the job API key it was sent with, if any, or else its
+// client.
+func jobOwner(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return "key:" + strings.TrimPrefix(auth, "Bearer ")
+	}
+	return "client:" + clientID(r)
+}
+
+// submitJobHandler starts a job for the code in a JsonnetRequest, answering
+// with the job to poll for the result.
+func submitJobHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxContentLength)
+	defer r.Body.Close()
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	e, err := jobEndpoint(r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		abuse.record(client, abuseTooLarge)
+		writeError(w, http.StatusRequestEntityTooLarge, errTooLarge())
+		return
+	}
+
+	req, err := evalEndpoint.decode(r, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := jobs.submit(e, client, jobOwner(r), req)
+	if err == errTooManyJobs || err == errOwnJobs {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Failed to start job: %v", err))
+		return
+	}
+
+	w.Header().Set("Location", apiPrefix+"/jobs/"+job.ID)
+	writeJob(w, http.StatusAccepted, job)
+}
+
+// jobHandler answers with the state of a job, including its result once
+// it's done.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.get(pathParam(r, "/jobs/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errJobNotFound)
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+// cancelJobHandler cancels a running job. Cancelling a job that's no longer
+// running leaves it as it is.
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.cancel(pathParam(r, "/jobs/"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errJobNotFound)
+		return
+	}
+	writeJob(w, http.StatusOK, job)
+}
+
+func writeJob(w http.ResponseWriter, status int, job Job) {
+	bytes, err := json.Marshal(job)
+	if err != nil {
+		log.Fatalf("Failed to serialize job JSON response:\n%v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
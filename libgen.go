@@ -0,0 +1,826 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// generatedMarker is the file that marks a library directory as generated,
+// holding the time it was generated at. Only generated libraries can be
+// replaced or deleted.
+const generatedMarker = ".generated"
+
+// ownerFile is the file in a generated library directory that holds who
+// generated it, as returned by libraryOwner.
+const ownerFile = ".owner"
+
+// maxLibDepth is how deeply mixins are generated for nested fields. Deeper
+// fields can still be set through the mixin above them.
+const maxLibDepth = 8
+
+var (
+	errLibraryNotFound    = errors.New("No such library")
+	errLibraryBuiltIn     = errors.New("Only generated libraries can be replaced or deleted")
+	errLibraryNotOwned    = errors.New("Only whoever generated a library can replace or delete it")
+	errTooManyLibraries   = errors.New("Too many generated libraries, please delete one first")
+	errTooManyOwned       = errors.New("You have generated too many libraries, please delete one first")
+	errNoKinds            = badInputError("Found no kinds in the swagger spec - Kinds are definitions with apiVersion, kind and metadata fields")
+	errLibrarySourceCount = badInputError("Expected either a swagger spec or a list of crds")
+	errLibraryTimeout     = badInputError("Generating the library took too long - Try a spec with fewer or simpler kinds")
+)
+
+// libraryLock serializes changes to generated libraries, so the limit on
+// their number holds.
+var libraryLock sync.Mutex
+
+// GenerateLibraryRequest asks for a library named Name, generated from
+// either a Kubernetes OpenAPI (swagger 2.0) spec or a list of
+// CustomResourceDefinitions.
+type GenerateLibraryRequest struct {
+	Name    string                   `json:"name"`
+	Swagger map[string]interface{}   `json:"swagger,omitempty"`
+	CRDs    []map[string]interface{} `json:"crds,omitempty"`
+}
+
+// libKind is a kind of object to generate a builder for.
+type libKind struct {
+	group, version, kind string
+	schema               *schemaNode
+}
+
+// apiVersion returns the apiVersion of objects of the kind.
+func (k libKind) apiVersion() string {
+	if k.group == "" {
+		return k.version
+	}
+	return k.group + "/" + k.version
+}
+
+// crdMetadata describes the metadata of custom resources, which CRD schemas
+// leave out.
+var crdMetadata = &schemaNode{Properties: map[string]*schemaNode{
+	"name":        {Type: "string"},
+	"namespace":   {Type: "string"},
+	"labels":      {Type: "object", AdditionalProperties: &schemaNode{Type: "string"}},
+	"annotations": {Type: "object", AdditionalProperties: &schemaNode{Type: "string"}},
+}}
+
+// swaggerKinds finds the kinds in a Kubernetes OpenAPI spec: the definitions
+// with apiVersion, kind and metadata fields, other than lists.
+func swaggerKinds(spec map[string]interface{}) (*k8sSchema, []libKind, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	var s k8sSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, nil, badInputError(fmt.Sprintf("Invalid swagger spec: %v", err))
+	}
+	s.indexKinds()
+
+	var kinds []libKind
+	for key, name := range s.kinds {
+		def := s.Definitions[name]
+		parts := strings.SplitN(key, "/", 3)
+		if def.Properties["apiVersion"] == nil || def.Properties["kind"] == nil || def.Properties["metadata"] == nil ||
+			strings.HasSuffix(parts[2], "List") {
+			continue
+		}
+		// Definitions without the extension are only known by the first
+		// label of their group
+		group := parts[0]
+		for _, gvk := range def.GroupVersionKinds {
+			if kindKey(gvk.Group, gvk.Version, gvk.Kind) == key {
+				group = gvk.Group
+			}
+		}
+		kinds = append(kinds, libKind{group: group, version: parts[1], kind: parts[2], schema: def})
+	}
+	return &s, kinds, nil
+}
+
+// customResourceDefinition is the part of a CustomResourceDefinition, in
+// apiextensions.k8s.io/v1beta1 or v1, that describes its kind.
+type customResourceDefinition struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Version    string `json:"version"`
+		Validation struct {
+			OpenAPIV3Schema *schemaNode `json:"openAPIV3Schema"`
+		} `json:"validation"`
+		Versions []struct {
+			Name   string `json:"name"`
+			Schema struct {
+				OpenAPIV3Schema *schemaNode `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+// crdKinds returns the kinds defined by CustomResourceDefinitions, one for
+// each version they serve.
+func crdKinds(crds []map[string]interface{}) ([]libKind, error) {
+	var kinds []libKind
+	for i, object := range crds {
+		data, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		var crd customResourceDefinition
+		if err := json.Unmarshal(data, &crd); err != nil {
+			return nil, badInputError(fmt.Sprintf("Invalid CustomResourceDefinition %d: %v", i, err))
+		}
+		if crd.Kind != "CustomResourceDefinition" || crd.Spec.Group == "" || crd.Spec.Names.Kind == "" {
+			return nil, badInputError(fmt.Sprintf("crds[%d] isn't a CustomResourceDefinition with a group and a kind", i))
+		}
+
+		schemas := map[string]*schemaNode{}
+		if crd.Spec.Version != "" {
+			schemas[crd.Spec.Version] = crd.Spec.Validation.OpenAPIV3Schema
+		}
+		for _, v := range crd.Spec.Versions {
+			schema := v.Schema.OpenAPIV3Schema
+			if schema == nil {
+				schema = crd.Spec.Validation.OpenAPIV3Schema
+			}
+			schemas[v.Name] = schema
+		}
+		if len(schemas) == 0 {
+			return nil, badInputError(fmt.Sprintf("CustomResourceDefinition %q has no versions", crd.Metadata.Name))
+		}
+
+		for version, schema := range schemas {
+			if err := validateName("version", version); err != nil {
+				return nil, badInputError(err.Error())
+			}
+			kind := &schemaNode{Type: "object", Properties: map[string]*schemaNode{}}
+			if schema != nil {
+				*kind = *schema
+				kind.Properties = map[string]*schemaNode{}
+				for k, v := range schema.Properties {
+					kind.Properties[k] = v
+				}
+			}
+			if kind.Properties["metadata"] == nil {
+				kind.Properties["metadata"] = crdMetadata
+			}
+			if schema == nil || schema.Properties["spec"] == nil {
+				// Without a schema the spec can only be set as a whole
+				kind.Properties["spec"] = &schemaNode{Type: "object", AdditionalProperties: &schemaNode{}}
+			}
+			kinds = append(kinds, libKind{group: crd.Spec.Group, version: version, kind: crd.Spec.Names.Kind, schema: kind})
+		}
+	}
+	return kinds, nil
+}
+
+// lowerCamel turns a kind into the name of its builder, like ksonnet-lib:
+// Deployment becomes deployment and APIService becomes apiService.
+func lowerCamel(kind string) string {
+	runes := []rune(kind)
+	upper := 0
+	for upper < len(runes) && unicode.IsUpper(runes[upper]) {
+		upper++
+	}
+	if upper > 1 && upper < len(runes) {
+		upper--
+	}
+	for i := 0; i < upper; i++ {
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// groupKey is the field a group's builders are under: core for the core
+// group, or the first label of the group's name.
+func groupKey(group string) string {
+	if group == "" {
+		return "core"
+	}
+	return strings.SplitN(group, ".", 2)[0]
+}
+
+// libWriter writes the jsonnet of a library's builders. Once ctx is done or
+// the schema turns out to be unusable, it stops writing and err says why.
+type libWriter struct {
+	buf    bytes.Buffer
+	schema *k8sSchema
+	indent int
+	ctx    context.Context
+	err    error
+}
+
+func (w *libWriter) line(format string, args ...interface{}) {
+	w.buf.WriteString(strings.Repeat("  ", w.indent))
+	fmt.Fprintf(&w.buf, format, args...)
+	w.buf.WriteString("\n")
+}
+
+// resolve follows the references of node to the schema it stands for,
+// returning it and the name of the definition it's from, if any. References
+// that lead back to a definition already followed fail the writer.
+func (w *libWriter) resolve(node *schemaNode) (*schemaNode, string) {
+	name := ""
+	seen := map[string]bool{}
+	for node.Ref != "" && w.schema != nil {
+		name = strings.TrimPrefix(node.Ref, "#/definitions/")
+		def, ok := w.schema.Definitions[name]
+		if !ok {
+			break
+		}
+		if seen[name] {
+			w.fail(badInputError(fmt.Sprintf("Definition %q refers to itself", name)))
+			return &schemaNode{}, name
+		}
+		seen[name] = true
+		node = def
+	}
+	return node, name
+}
+
+// fail stops the writer with err, unless it already stopped.
+func (w *libWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// paramName is the name of the parameter that sets field.
+func paramName(field string) string {
+	if identifierRegexp.MatchString(field) && !jsonnetKeywords[field] && field != "std" {
+		return field
+	}
+	return "value"
+}
+
+// mixinName is the name of the local function a mixin of field is built
+// with.
+func mixinName(field string) string {
+	return "__" + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, field) + "Mixin"
+}
+
+// setterValue returns the value a setter of field sets it to: maps and
+// arrays are merged and appended to, and a single item is taken for an
+// array of one.
+func setterValue(field string, node *schemaNode) string {
+	key, param := formatKey(field), paramName(field)
+	switch {
+	case node.Type == "array":
+		return fmt.Sprintf("{%s+: if std.type(%s) == \"array\" then %s else [%s]}", key, param, param, param)
+	case node.AdditionalProperties != nil || node.Type == "object":
+		return fmt.Sprintf("{%s+: %s}", key, param)
+	}
+	return fmt.Sprintf("{%s: %s}", key, param)
+}
+
+// hasFields reports whether node is an object with fields of its own to
+// generate mixins for.
+func hasFields(node *schemaNode) bool {
+	return len(node.Properties) > 0 && node.AdditionalProperties == nil
+}
+
+// sortedFields returns the names of the fields of node, leaving out those
+// that would clash with the names of the generated functions.
+func sortedFields(node *schemaNode) []string {
+	var fields []string
+	for field := range node.Properties {
+		if field != "new" && field != "mixin" && field != "mixinInstance" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// writeFields writes the builders for the fields of node. parent is the
+// local function that wraps a change into one to the object node is a field
+// of, or "" for the object itself. Setters return the object to add to set a
+// field. Fields of nested objects get mixins, under mixin for the object
+// itself, and arrays of objects get a <field>Type with builders for their
+// items. seen holds the definitions being written, so recursive ones stop.
+func (w *libWriter) writeFields(node *schemaNode, parent string, skip map[string]bool, depth int, seen map[string]bool) {
+	if err := w.ctx.Err(); err != nil {
+		w.fail(errLibraryTimeout)
+	}
+	if w.err != nil {
+		return
+	}
+	var nested []string
+	for _, field := range sortedFields(node) {
+		if skip[field] {
+			continue
+		}
+		prop, name := w.resolve(node.Properties[field])
+		if hasFields(prop) && !seen[name] && depth < maxLibDepth {
+			nested = append(nested, field)
+			continue
+		}
+		value := setterValue(field, prop)
+		if parent != "" {
+			value = "self.mixinInstance(" + value + ")"
+		}
+		w.line("%s(%s):: %s,", formatKey(field), paramName(field), value)
+		if prop.Type == "array" && prop.Items != nil {
+			item, itemName := w.resolve(prop.Items)
+			if hasFields(item) && !seen[itemName] && depth < maxLibDepth {
+				w.line("%s:: {", formatKey(field+"Type"))
+				w.indent++
+				w.line("new():: {},")
+				w.writeType(item, itemName, "", nil, depth+1, seen)
+				w.indent--
+				w.line("},")
+			}
+		}
+	}
+	if len(nested) == 0 {
+		return
+	}
+
+	if parent == "" {
+		w.line("mixin:: {")
+		w.indent++
+	}
+	for _, field := range nested {
+		prop, name := w.resolve(node.Properties[field])
+		key, param := formatKey(field), paramName(field)
+		// The local can't shadow the one it calls, as in spec.template.spec
+		local := mixinName(field)
+		if local == parent {
+			local += "Nested"
+		}
+		w.line("%s:: {", key)
+		w.indent++
+		if parent == "" {
+			w.line("local %s(%s) = {%s+: %s},", local, param, key, param)
+		} else {
+			w.line("local %s(%s) = %s({%s+: %s}),", local, param, parent, key, param)
+		}
+		w.line("mixinInstance(%s):: %s(%s),", param, local, param)
+		w.writeType(prop, name, local, nil, depth+1, seen)
+		w.indent--
+		w.line("},")
+	}
+	if parent == "" {
+		w.indent--
+		w.line("},")
+	}
+}
+
+// writeType writes the builders for the fields of node, which is the
+// definition named name, if any, and is wrapped by the parent mixin, if any.
+func (w *libWriter) writeType(node *schemaNode, name, parent string, skip map[string]bool, depth int, seen map[string]bool) {
+	if name != "" {
+		seen[name] = true
+		defer delete(seen, name)
+	}
+	w.writeFields(node, parent, skip, depth, seen)
+}
+
+// kindFields are the fields of kinds that their builders leave out: new()
+// sets apiVersion and kind, and status is set by the cluster.
+var kindFields = map[string]bool{"apiVersion": true, "kind": true, "status": true}
+
+// checkKinds returns an error if the builders of two kinds would end up in
+// the same place: groups are laid out by the first label of their name, so
+// two groups can't share it, and a group can only define a kind once per
+// version.
+func checkKinds(kinds []libKind) error {
+	groups := map[string]string{}
+	builders := map[string]string{}
+	for _, k := range kinds {
+		key := groupKey(k.group)
+		if other, ok := groups[key]; ok && other != k.group {
+			return badInputError(fmt.Sprintf("Groups %q and %q would both be under %s - Generate them in separate libraries", other, k.group, key))
+		}
+		groups[key] = k.group
+
+		builder := key + "." + k.version + "." + lowerCamel(k.kind)
+		if other, ok := builders[builder]; ok {
+			if other == k.kind {
+				return badInputError(fmt.Sprintf("Kind %s is defined more than once in %s", k.kind, k.apiVersion()))
+			}
+			return badInputError(fmt.Sprintf("Kinds %s and %s in %s would both be built by %s", other, k.kind, k.apiVersion(), lowerCamel(k.kind)))
+		}
+		builders[builder] = k.kind
+	}
+	return nil
+}
+
+// generateLibrary returns the files of a library with builders for kinds,
+// laid out like ksonnet-lib: k8s.libsonnet has the builders by group,
+// version and kind, and k.libsonnet is what code imports. It gives up once
+// ctx is done.
+func generateLibrary(ctx context.Context, schema *k8sSchema, kinds []libKind, source string) (map[string]string, error) {
+	if err := checkKinds(kinds); err != nil {
+		return nil, err
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		a, b := kinds[i], kinds[j]
+		if groupKey(a.group) != groupKey(b.group) {
+			return groupKey(a.group) < groupKey(b.group)
+		}
+		if a.version != b.version {
+			return a.version < b.version
+		}
+		return a.kind < b.kind
+	})
+
+	w := &libWriter{schema: schema, ctx: ctx}
+	w.line("// Generated by the ksonnet playground from %s.", source)
+	w.line("{")
+	w.indent++
+	for i := 0; i < len(kinds); {
+		group := groupKey(kinds[i].group)
+		w.line("%s:: {", formatKey(group))
+		w.indent++
+		for i < len(kinds) && groupKey(kinds[i].group) == group {
+			version := kinds[i].version
+			w.line("%s:: {", formatKey(version))
+			w.indent++
+			for ; i < len(kinds) && groupKey(kinds[i].group) == group && kinds[i].version == version; i++ {
+				k := kinds[i]
+				w.line("%s:: {", formatKey(lowerCamel(k.kind)))
+				w.indent++
+				w.line("new():: {apiVersion: %s, kind: %s},", strconv.Quote(k.apiVersion()), strconv.Quote(k.kind))
+				w.writeType(k.schema, "", "", kindFields, 0, map[string]bool{})
+				w.indent--
+				w.line("},")
+			}
+			w.indent--
+			w.line("},")
+		}
+		w.indent--
+		w.line("},")
+	}
+	w.indent--
+	w.line("}")
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	example := fmt.Sprintf("k.%s.%s.%s", formatKey(groupKey(kinds[0].group)), formatKey(kinds[0].version), formatKey(lowerCamel(kinds[0].kind)))
+	return map[string]string{
+		"k8s.libsonnet": w.buf.String(),
+		"k.libsonnet": fmt.Sprintf(`// Generated by the ksonnet playground from %s.
+// The builders are in k8s.libsonnet, by group, version and kind, e.g.
+// %s.new() + %s.mixin.metadata.name("example")
+local k8s = import "k8s.libsonnet";
+
+k8s
+`, source, example, example),
+	}, nil
+}
+
+// libraryRevision returns when the named library was generated, or "" for
+// libraries that weren't, so results cached for a library that has since
+// been replaced aren't used.
+func libraryRevision(name string) string {
+	marker, _ := ioutil.ReadFile(filepath.Join(config.LibraryDir, name, generatedMarker))
+	return string(marker)
+}
+
+// isGenerated reports whether the library directory at dir was generated.
+func isGenerated(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, generatedMarker))
+	return err == nil
+}
+
+// touchLibrary notes that the named library was just used, if it was
+// generated, so it doesn't expire. The modification time of its marker is
+// when it was last used.
+func touchLibrary(name string) {
+	marker := filepath.Join(config.LibraryDir, name, generatedMarker)
+	now := time.Now()
+	if err := os.Chtimes(marker, now, now); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not note the use of library %s: %v", name, err)
+	}
+}
+
+// generatedLibrary is a generated library in the library directory.
+type generatedLibrary struct {
+	name, owner string
+	lastUsed    time.Time
+}
+
+// generatedLibraries lists the generated libraries in the library
+// directory. Callers hold libraryLock.
+func generatedLibraries() ([]generatedLibrary, error) {
+	dirs, err := ioutil.ReadDir(config.LibraryDir)
+	if err != nil {
+		return nil, err
+	}
+	var libs []generatedLibrary
+	for _, d := range dirs {
+		dir := filepath.Join(config.LibraryDir, d.Name())
+		if !d.IsDir() {
+			continue
+		}
+		marker, err := os.Stat(filepath.Join(dir, generatedMarker))
+		if err != nil {
+			continue
+		}
+		owner, _ := ioutil.ReadFile(filepath.Join(dir, ownerFile))
+		libs = append(libs, generatedLibrary{name: d.Name(), owner: string(owner), lastUsed: marker.ModTime()})
+	}
+	return libs, nil
+}
+
+// expireLibraries deletes the generated libraries that haven't been used
+// for config.LibraryIdle. Callers hold libraryLock.
+func expireLibraries() error {
+	if config.LibraryIdle <= 0 {
+		return nil
+	}
+	libs, err := generatedLibraries()
+	if err != nil {
+		return err
+	}
+	for _, lib := range libs {
+		if time.Since(lib.lastUsed) < config.LibraryIdle {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(config.LibraryDir, lib.name)); err != nil {
+			return err
+		}
+		log.Printf("Deleted generated library %s, unused since %v", lib.name, lib.lastUsed)
+	}
+	return nil
+}
+
+// expireLibrariesEvery deletes idle generated libraries every interval.
+func expireLibrariesEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		libraryLock.Lock()
+		if err := expireLibraries(); err != nil {
+			log.Printf("Could not expire generated libraries: %v", err)
+		}
+		libraryLock.Unlock()
+	}
+}
+
+// libraryOwner identifies who sent r for owning generated libraries: the
+// bearer token in its Authorization header, if any, or else its client.
+// Only a hash of it is kept.
+func libraryOwner(r *http.Request) string {
+	owner := "client:" + clientID(r)
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		owner = "key:" + strings.TrimPrefix(auth, "Bearer ")
+	}
+	sum := sha256.Sum256([]byte(owner))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkOwner returns errLibraryNotOwned unless the generated library in dir
+// belongs to owner.
+func checkOwner(dir, owner string) error {
+	recorded, err := ioutil.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if string(recorded) != owner {
+		return errLibraryNotOwned
+	}
+	return nil
+}
+
+// saveLibrary writes the files of a library generated by owner to the
+// library directory, replacing the one of the same name owner generated
+// before, if any. Idle libraries are deleted first, so they don't count
+// against the limits.
+func saveLibrary(name, owner string, files map[string]string) error {
+	libraryLock.Lock()
+	defer libraryLock.Unlock()
+
+	if err := expireLibraries(); err != nil {
+		return err
+	}
+	dir := filepath.Join(config.LibraryDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		if !isGenerated(dir) {
+			return errLibraryBuiltIn
+		}
+		if err := checkOwner(dir, owner); err != nil {
+			return err
+		}
+	} else {
+		libs, err := generatedLibraries()
+		if err != nil {
+			return err
+		}
+		owned := 0
+		for _, lib := range libs {
+			if lib.owner == owner {
+				owned++
+			}
+		}
+		if len(libs) >= config.MaxGeneratedLibraries {
+			return errTooManyLibraries
+		}
+		if owned >= config.MaxLibrariesPerOwner {
+			return errTooManyOwned
+		}
+	}
+
+	// Write the library next to where it goes, so it's replaced in one step
+	tmp, err := ioutil.TempDir(config.LibraryDir, ".generating-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	files[generatedMarker] = time.Now().UTC().Format(time.RFC3339Nano)
+	files[ownerFile] = owner
+	for file, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(tmp, file), []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// libraryStatus returns the HTTP status to send an error from generating or
+// deleting a library with.
+func libraryStatus(err error) int {
+	if _, ok := err.(badInputError); ok {
+		return http.StatusBadRequest
+	}
+	switch err {
+	case errLibraryNotFound:
+		return http.StatusNotFound
+	case errLibraryBuiltIn:
+		return http.StatusConflict
+	case errLibraryNotOwned:
+		return http.StatusForbidden
+	case errTooManyLibraries, errTooManyOwned:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// generateLibraryHandler generates a library from the spec or CRDs in a
+// GenerateLibraryRequest, answering with the new Library.
+func generateLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxSpecBytes)
+	defer r.Body.Close()
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		abuse.record(client, abuseTooLarge)
+		writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("Request too large - Specs must be smaller than %v bytes", config.MaxSpecBytes))
+		return
+	}
+	var req GenerateLibraryRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateName("library", req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var schema *k8sSchema
+	var kinds []libKind
+	var source string
+	switch {
+	case (req.Swagger == nil) == (len(req.CRDs) == 0):
+		err = errLibrarySourceCount
+	case req.Swagger != nil:
+		schema, kinds, err = swaggerKinds(req.Swagger)
+		source = "a Kubernetes OpenAPI spec"
+	default:
+		kinds, err = crdKinds(req.CRDs)
+		source = "CustomResourceDefinitions"
+	}
+	if err == nil && len(kinds) == 0 {
+		err = errNoKinds
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), config.LibraryGenerateTimeout)
+		var files map[string]string
+		files, err = generateLibrary(ctx, schema, kinds, source)
+		cancel()
+		if err == nil {
+			err = saveLibrary(req.Name, libraryOwner(r), files)
+		}
+	}
+	if err != nil {
+		writeError(w, libraryStatus(err), err)
+		return
+	}
+
+	lib, err := readLibrary(req.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lib)
+}
+
+// deleteLibraryHandler deletes a generated library, answering with it. Only
+// whoever generated it can.
+func deleteLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "/libraries/")
+	if validateName("library", name) != nil {
+		writeError(w, http.StatusNotFound, errLibraryNotFound)
+		return
+	}
+	lib, err := readLibrary(name)
+	if os.IsNotExist(err) {
+		err = errLibraryNotFound
+	} else if err == nil {
+		err = deleteLibrary(name, libraryOwner(r))
+	}
+	if err != nil {
+		writeError(w, libraryStatus(err), err)
+		return
+	}
+	writeJSON(w, lib)
+}
+
+// deleteLibrary removes a library owner generated from the library
+// directory. Operators delete libraries regardless of who generated them
+// with an empty owner.
+func deleteLibrary(name, owner string) error {
+	libraryLock.Lock()
+	defer libraryLock.Unlock()
+
+	dir := filepath.Join(config.LibraryDir, name)
+	if !isGenerated(dir) {
+		return errLibraryBuiltIn
+	}
+	if owner != "" {
+		if err := checkOwner(dir, owner); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// adminLibraryHandler lets operators delete any generated library, such as
+// one with abusive contents, answering with it.
+func adminLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/admin/libraries/")
+	if validateName("library", name) != nil {
+		http.Error(w, errLibraryNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	lib, err := readLibrary(name)
+	if os.IsNotExist(err) {
+		err = errLibraryNotFound
+	} else if err == nil {
+		err = deleteLibrary(name, "")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), libraryStatus(err))
+		return
+	}
+	log.Printf("Deleted generated library %s for an operator", name)
+	writeJSON(w, lib)
+}
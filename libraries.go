@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Library is a version of ksonnet-lib (or any other jsonnet library) that
+// code can import, e.g. `import "ksonnet.beta.2/k.libsonnet"`.
+type Library struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+}
+
+// LibrariesResponse lists the libraries available to jsonnet code. Files of
+// the Default library can also be imported without the directory prefix.
+type LibrariesResponse struct {
+	Libraries []Library `json:"libraries"`
+	Default   string    `json:"default"`
+}
+
+// listLibraries finds the libraries in the library directory: every
+// subdirectory that holds at least one .libsonnet file.
+func listLibraries() ([]Library, error) {
+	dirs, err := ioutil.ReadDir(config.LibraryDir)
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := []Library{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		lib, err := readLibrary(dir.Name())
+		if err != nil {
+			return nil, err
+		}
+		if len(lib.Files) > 0 {
+			libraries = append(libraries, lib)
+		}
+	}
+	return libraries, nil
+}
+
+// readLibrary lists the .libsonnet files of the named library.
+func readLibrary(name string) (Library, error) {
+	files, err := ioutil.ReadDir(filepath.Join(config.LibraryDir, name))
+	if err != nil {
+		return Library{}, err
+	}
+	lib := Library{Name: name}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".libsonnet") {
+			lib.Files = append(lib.Files, f.Name())
+		}
+	}
+	return lib, nil
+}
+
+func librariesHandler(w http.ResponseWriter, r *http.Request) {
+	libraries, err := listLibraries()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Could not list libraries: %v", err))
+		return
+	}
+
+	resp := LibrariesResponse{
+		Libraries: libraries,
+		Default:   filepath.Base(config.ExtraImportPath),
+	}
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Write(bytes)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// LiveRequest is a code edit sent by the client over a live session. Each
+// edit carries the whole code, and a client-chosen ID that the server's
+// reply refers back to.
+type LiveRequest struct {
+	ID   int64  `json:"id"`
+	Code string `json:"code"`
+}
+
+// LiveResponse answers the LiveRequest with the same ID. Status is "result"
+// when the edit was evaluated, with the outcome in Result, or "superseded"
+// when a newer edit replaced it before it was done.
+type LiveResponse struct {
+	ID     int64            `json:"id"`
+	Status string           `json:"status"`
+	Result *JsonnetResponse `json:"result,omitempty"`
+}
+
+const (
+	liveResult     = "result"
+	liveSuperseded = "superseded"
+)
+
+var errNotWebSocket = errors.New("Expected a WebSocket upgrade request")
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || config.SkipCorsCheck || originRegexp.MatchString(origin)
+	},
+}
+
+// liveHandler upgrades the request to a WebSocket over which the client
+// streams code edits and receives evaluation results, without paying for a
+// request per edit.
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeError(w, http.StatusBadRequest, errNotWebSocket)
+		return
+	}
+
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded
+		return
+	}
+	defer conn.Close()
+
+	p8sLiveSessions.Inc()
+	defer p8sLiveSessions.Dec()
+
+	s := &liveSession{conn: conn, client: client}
+	s.run(r.Context())
+}
+
+// liveSession is one client's WebSocket session. Edits are debounced: an
+// edit is only evaluated once no newer one arrived for the debounce period,
+// and an evaluation still running when a newer edit arrives is cancelled.
+type liveSession struct {
+	conn   *websocket.Conn
+	client string
+}
+
+// liveEvaluation is the outcome of evaluating one edit.
+type liveEvaluation struct {
+	id     int64
+	result CachedResult
+}
+
+// liveRun is an evaluation in progress.
+type liveRun struct {
+	id     int64
+	cancel context.CancelFunc
+}
+
+// readEdits forwards edits from the client until the connection closes or
+// the session ends.
+func (s *liveSession) readEdits(ctx context.Context, edits chan<- LiveRequest) {
+	defer close(edits)
+
+	// Leave room for the JSON framing around code of the maximum length, so
+	// oversized code can be answered with an error instead of a hang-up.
+	s.conn.SetReadLimit(2*config.MaxContentLength + 1024)
+	for {
+		var req LiveRequest
+		if err := s.conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Live session for client %s ended: %v", s.client, err)
+			}
+			return
+		}
+		select {
+		case edits <- req:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *liveSession) run(ctx context.Context) {
+	ctx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+
+	edits := make(chan LiveRequest)
+	go s.readEdits(ctx, edits)
+
+	// Only this goroutine writes to the connection. pending is the latest
+	// edit waiting out the debounce period, running the one being evaluated.
+	var (
+		pending  *LiveRequest
+		debounce <-chan time.Time
+		running  *liveRun
+		results  = make(chan liveEvaluation)
+	)
+	for {
+		select {
+		case req, ok := <-edits:
+			if !ok {
+				return
+			}
+			if pending != nil && !s.send(LiveResponse{ID: pending.ID, Status: liveSuperseded}) {
+				return
+			}
+			if running != nil {
+				running.cancel()
+				if !s.send(LiveResponse{ID: running.id, Status: liveSuperseded}) {
+					return
+				}
+				running = nil
+			}
+			pending = &req
+			debounce = time.After(config.LiveDebounce)
+
+		case <-debounce:
+			req := *pending
+			pending, debounce = nil, nil
+
+			if err := s.check(req); err != nil {
+				if !s.send(LiveResponse{ID: req.ID, Status: liveResult, Result: &err.Response}) {
+					return
+				}
+				continue
+			}
+
+			running = s.start(ctx, req, results)
+
+		case eval := <-results:
+			// Results of cancelled evaluations were already answered
+			if running == nil || running.id != eval.id {
+				continue
+			}
+			running.cancel()
+			running = nil
+			if !s.send(LiveResponse{ID: eval.id, Status: liveResult, Result: &eval.result.Response}) {
+				return
+			}
+		}
+	}
+}
+
+// start evaluates req in the background, delivering the outcome to results
+// unless the session ends first.
+func (s *liveSession) start(ctx context.Context, req LiveRequest, results chan<- liveEvaluation) *liveRun {
+	evalCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		result := evalEndpoint.evaluate(evalCtx, s.client, JsonnetRequest{Code: req.Code})
+		select {
+		case results <- liveEvaluation{req.ID, result}:
+		case <-ctx.Done():
+		}
+	}()
+	return &liveRun{id: req.ID, cancel: cancel}
+}
+
+// check applies the per-request limits of the HTTP API to an edit, returning
+// the error result if the edit may not be evaluated.
+func (s *liveSession) check(req LiveRequest) *CachedResult {
+	if err := clientBlocked(s.client); err != nil {
+		result := errorResult(http.StatusForbidden, err)
+		return &result
+	}
+	if int64(len(req.Code)) > config.MaxContentLength {
+		abuse.record(s.client, abuseTooLarge)
+		result := errorResult(http.StatusRequestEntityTooLarge, errTooLarge())
+		return &result
+	}
+	return nil
+}
+
+// send writes res to the client, reporting whether the session is still up.
+func (s *liveSession) send(res LiveResponse) bool {
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := s.conn.WriteJSON(res); err != nil {
+		log.Printf("Live session for client %s failed to send: %v", s.client, err)
+		return false
+	}
+	return true
+}
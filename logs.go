@@ -0,0 +1,81 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LogKind tells std.trace output from other messages in a LogLine.
+type LogKind string
+
+const (
+	LogTrace   LogKind = "trace"
+	LogWarning LogKind = "warning"
+)
+
+// enum lists the possible values, for the OpenAPI spec.
+func (LogKind) enum() []string {
+	return []string{string(LogTrace), string(LogWarning)}
+}
+
+// LogLine is a message jsonnet wrote to stderr besides the error itself,
+// such as std.trace output. File and Line locate the code that wrote it,
+// when jsonnet said.
+type LogLine struct {
+	Kind    LogKind `json:"kind"`
+	Message string  `json:"message"`
+	File    string  `json:"file,omitempty"`
+	Line    int     `json:"line,omitempty"`
+	Column  int     `json:"column,omitempty"`
+}
+
+const tracePrefix = "TRACE: "
+
+var (
+	// locationRegexp matches the source location starting a message, e.g.
+	// "snippet:3 " for std.trace or "snippet:3:5-9 " for warnings.
+	locationRegexp = regexp.MustCompile(`^(\S+?):(\d+)(?::(\d+)(?:-\d+)?)?:? (.*)$`)
+	// errorRegexp matches the line jsonnet's error message starts with
+	errorRegexp = regexp.MustCompile(`^(STATIC|RUNTIME) ERROR`)
+)
+
+// parseStderr splits what jsonnet wrote to stderr into log lines and, if the
+// run failed, the error message. The error starts at its first line, and
+// lines after a trace that don't start with a source location of their own
+// continue it.
+func parseStderr(stderr []byte, failed bool) (string, []LogLine) {
+	var logs []LogLine
+	lines := strings.Split(strings.TrimSuffix(string(stderr), "\n"), "\n")
+	for i, line := range lines {
+		switch {
+		case line == "" && len(lines) == 1:
+			return "", nil
+		case failed && errorRegexp.MatchString(line):
+			return strings.Join(lines[i:], "\n") + "\n", logs
+		case strings.HasPrefix(line, tracePrefix):
+			logs = append(logs, newLogLine(LogTrace, strings.TrimPrefix(line, tracePrefix)))
+		case len(logs) > 0 && logs[len(logs)-1].Kind == LogTrace && !locationRegexp.MatchString(line):
+			last := &logs[len(logs)-1]
+			last.Message += "\n" + line
+		case !failed:
+			logs = append(logs, newLogLine(LogWarning, line))
+		default:
+			// An error without the usual header
+			return strings.Join(lines[i:], "\n") + "\n", logs
+		}
+	}
+	return "", logs
+}
+
+// newLogLine parses the source location off the front of message, if there
+// is one.
+func newLogLine(kind LogKind, message string) LogLine {
+	log := LogLine{Kind: kind, Message: message}
+	if m := locationRegexp.FindStringSubmatch(message); m != nil {
+		log.File, log.Message = m[1], m[4]
+		log.Line, _ = strconv.Atoi(m[2])
+		log.Column, _ = strconv.Atoi(m[3])
+	}
+	return log
+}
@@ -6,162 +6,416 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
 	"os/exec"
-	"regexp"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/ghodss/yaml"
-	"github.com/heptio/ksonnet-playground/api"
 	"github.com/karlseguin/ccache"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/time/rate"
 )
 
 var (
-	limiter      *rate.Limiter
-	codeCache    *ccache.Cache
-	errBusy      = errors.New("Server is busy, please try again")
-	errTimeout   = errors.New("Jsonnet evaluation timed out")
-	originRegexp = regexp.MustCompile(`^https?://.*\.heptio\.com|localhost:\d+$`)
+	limiter    *rate.Limiter
+	codeCache  *ccache.Cache
+	errBusy    = errors.New("Server is busy, please try again")
+	errTimeout = errors.New("Jsonnet evaluation timed out")
 )
 
 // JsonnetRequest represents a request from the client, containing
 // code to be executed.
 type JsonnetRequest struct {
 	Code string `json:"code"`
+	// Validate checks the Kubernetes objects the code evaluates to against
+	// the OpenAPI spec of K8sVersion, or the default version if it's empty.
+	Validate   bool   `json:"validate,omitempty"`
+	K8sVersion string `json:"k8sVersion,omitempty"`
+	// Order sorts the Kubernetes objects the code evaluates to into a List
+	// in an order they can be applied in.
+	Order bool `json:"order,omitempty"`
+	// Library is the library in the library directory whose files can be
+	// imported without the directory prefix, instead of the default one.
+	Library string `json:"library,omitempty"`
 }
 
 // JsonnetResponse represents a response containing the result of some
 // piece of code that was meant to be executed. The response is either
 // an `Error` message, or an `Output` string containing syntactically
-// valid JSON.
+// valid JSON. Errors also carry a `Code` classifying them, and whether
+// the request is worth retrying. `Logs` holds std.trace output and
+// warnings either way.
 type JsonnetResponse struct {
-	Error  *string `json:"error"`
-	Output *string `json:"output"`
+	Error     *string   `json:"error"`
+	Output    *string   `json:"output"`
+	Code      ErrorCode `json:"code,omitempty"`
+	Retryable bool      `json:"retryable"`
+	Logs      []LogLine `json:"logs,omitempty"`
+	// Diagnostics lists the problems validation found with the output, if
+	// the request asked for it.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// Order gives the reason for the position of each object in the
+	// output, if the request asked to order it.
+	Order []OrderedObject `json:"order,omitempty"`
 }
 
 // CachedResult is the results of a jsonnet response, stored in an LRU cache.
-// It contains the body and HTTP code we should respond with when we see a
-// given request body.
+// It contains the response and HTTP code we should respond with when we see a
+// given request.
 type CachedResult struct {
-	Response string
+	Response JsonnetResponse
 	HTTPCode int
 }
 
-// errorResponse turns an error into a `JsonnetResponse`, serialized
-// as a string.
-func errorResponse(output string, err error) string {
+// newErrorResponse turns an error into a `JsonnetResponse`.
+func newErrorResponse(code ErrorCode, output string, err error) JsonnetResponse {
 	errorString := fmt.Sprintf("%s\n%s", err.Error(), output)
-	res := JsonnetResponse{
-		Error: &errorString,
+	return JsonnetResponse{
+		Error:     &errorString,
+		Code:      code,
+		Retryable: code.retryable(),
 	}
-	bytes, err := json.Marshal(res)
-	if err != nil {
-		log.Fatalf("Failed to serialize success JSON response:\n%v", err)
-	}
-	return string(bytes)
 }
 
-// successResponse turns the string output of a Jsonnet run into a
-// `JsonnetResponse`, serialized as a string.
-func successResponse(output string) string {
-	res := JsonnetResponse{
-		Output: &output,
+// errorResult is the CachedResult for an error fully described by the HTTP
+// status it's sent with.
+func errorResult(status int, err error) CachedResult {
+	return CachedResult{
+		HTTPCode: status,
+		Response: newErrorResponse(statusErrorCode(status), "", err),
 	}
-	bytes, err := json.Marshal(res)
+}
+
+// errorResponse turns an error into a `JsonnetResponse`, serialized
+// as a string.
+func errorResponse(code ErrorCode, output string, err error) string {
+	bytes, err := json.Marshal(newErrorResponse(code, output, err))
 	if err != nil {
 		log.Fatalf("Failed to serialize success JSON response:\n%v", err)
 	}
 	return string(bytes)
 }
 
-// runJsonnet wraps the execution of the jsonnet command.
-// The output bytes are included even when there was an error.
-func runJsonnet(ctx context.Context, code string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, config.JsonnetRunTimeout)
+// runTimeoutKey is the context key for a jsonnet run timeout other than the
+// configured one.
+type runTimeoutKey struct{}
+
+// withRunTimeout returns a context under which jsonnet runs for up to timeout
+// instead of the configured jsonnet run timeout.
+func withRunTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, runTimeoutKey{}, timeout)
+}
+
+// importPathKey is the context key for a jsonnet import path other than the
+// configured one.
+type importPathKey struct{}
+
+// withImportPath returns a context under which code is evaluated with dir as
+// the import path instead of the configured one.
+func withImportPath(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, importPathKey{}, dir)
+}
+
+// execJsonnet runs the jsonnet command with the given arguments, killing it
+// if it runs past the configured timeout. What jsonnet wrote to stdout and
+// stderr is included even when there was an error.
+func execJsonnet(ctx context.Context, args ...string) ([]byte, []byte, error) {
+	return execCommand(ctx, "jsonnet", args...)
+}
+
+// formatter is the command and arguments that run the jsonnet formatter,
+// as found by formatCommand.
+var formatter struct {
+	sync.Once
+	command []string
+}
+
+// formatCommand returns the command and arguments that run the jsonnet
+// formatter: jsonnetfmt, which jsonnet v0.13 and later have instead of
+// jsonnet fmt, if it's installed, or else jsonnet fmt.
+func formatCommand() []string {
+	formatter.Do(func() {
+		if _, err := exec.LookPath("jsonnetfmt"); err == nil {
+			formatter.command = []string{"jsonnetfmt"}
+		} else {
+			formatter.command = []string{"jsonnet", "fmt"}
+		}
+	})
+	return formatter.command
+}
+
+// execFormatter runs the jsonnet formatter with the given arguments, like
+// execJsonnet.
+func execFormatter(ctx context.Context, args ...string) ([]byte, []byte, error) {
+	command := formatCommand()
+	return execCommand(ctx, command[0], append(command[1:len(command):len(command)], args...)...)
+}
+
+// execCommand runs a jsonnet command with the given arguments, killing it if
+// it runs past the configured timeout.
+func execCommand(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	timeout := config.JsonnetRunTimeout
+	if t, ok := ctx.Value(runTimeoutKey{}).(time.Duration); ok {
+		timeout = t
+	}
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	outBytes, err := exec.CommandContext(ctx,
-		"jsonnet",
-		"-J", config.ExtraImportPath,
-		"-e", code).CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
+	// std.trace lines are also reported as they're written
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(runCtx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.MultiWriter(&stderr, &traceWriter{ctx: ctx})
+	err := cmd.Run()
+	if runCtx.Err() == context.DeadlineExceeded {
 		p8sTimeoutRequests.Inc()
 		err = errTimeout
 	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
 
-	// Convert to yaml
-	if err == nil {
-		outBytes, err = yaml.JSONToYAML(outBytes)
+// runJsonnet wraps the execution of the jsonnet command.
+// The error message is returned as the output when there was an error.
+func runJsonnet(ctx context.Context, code string) (string, []LogLine, error) {
+	importPath := config.ExtraImportPath
+	if dir, ok := ctx.Value(importPathKey{}).(string); ok {
+		importPath = dir
 	}
-	return string(outBytes), err
+	stdout, stderr, err := execJsonnet(ctx,
+		"-J", importPath,
+		"-e", code)
+	errOutput, logs := parseStderr(stderr, err != nil)
+	if err != nil {
+		return errOutput, logs, err
+	}
+
+	// Convert to yaml
+	outBytes, err := yaml.JSONToYAML(stdout)
+	return string(outBytes), logs, err
 }
 
-func makeJsonnetCache(ctx context.Context, body []byte) CachedResult {
-	// Decode the body and convert it
-	decoder := json.NewDecoder(bytes.NewBuffer(body))
-	var req JsonnetRequest
-	err := decoder.Decode(&req)
+// formatJsonnet runs the code through the jsonnet formatter, returning the
+// reformatted code.
+func formatJsonnet(ctx context.Context, code string) (string, []LogLine, error) {
+	stdout, stderr, err := execFormatter(ctx, "-e", code)
+	errOutput, logs := parseStderr(stderr, err != nil)
 	if err != nil {
-		return CachedResult{
-			HTTPCode: http.StatusBadRequest,
-			Response: errorResponse("", err),
-		}
+		return errOutput, logs, err
 	}
+	return string(stdout), logs, nil
+}
+
+// checkJsonnet reports whether the code parses, without evaluating it. The
+// output is empty on success.
+func checkJsonnet(ctx context.Context, code string) (string, []LogLine, error) {
+	_, stderr, err := execFormatter(ctx, "-e", code)
+	errOutput, logs := parseStderr(stderr, err != nil)
+	return errOutput, logs, err
+}
 
-	outBytes, err := runJsonnet(ctx, req.Code)
+// jsonnetOp is an operation run on the code of a JsonnetRequest, such as
+// runJsonnet or formatJsonnet. It returns its output, or jsonnet's error
+// message if it failed, and any other messages jsonnet printed.
+type jsonnetOp func(ctx context.Context, code string) (string, []LogLine, error)
+
+func makeJsonnetCache(ctx context.Context, op jsonnetOp, req JsonnetRequest) CachedResult {
+	output, logs, err := op(ctx, req.Code)
 
 	if err != nil {
+		code, status := jsonnetErrorCode(err, output)
+		response := newErrorResponse(code, output, err)
+		response.Logs = logs
 		return CachedResult{
-			HTTPCode: http.StatusBadRequest,
-			Response: errorResponse(string(outBytes), err),
+			HTTPCode: status,
+			Response: response,
 		}
 	}
 
 	return CachedResult{
 		HTTPCode: http.StatusOK,
-		Response: successResponse(string(outBytes)),
+		Response: JsonnetResponse{Output: &output, Logs: logs},
 	}
 }
 
+// jsonnetEndpoint is an API endpoint that runs an operation on submitted
+// code. Endpoints that accept raw input also take the code as the whole
+// request body, and can answer with the raw output in one of the raw media
+// types instead of the JSON envelope (see negotiate.go).
+type jsonnetEndpoint struct {
+	// name distinguishes operations in the cache
+	name     string
+	op       jsonnetOp
+	rawInput bool
+	// raw converts the output of op into each raw media type, the first of
+	// which is used when a client that sent raw code accepts anything.
+	raw []rawType
+	// timeout overrides the jsonnet run timeout when set
+	timeout time.Duration
+	// stream is set for endpoints that can report progress as server-sent
+	// events (see stream.go)
+	stream bool
+	// objects is set for endpoints whose output is Kubernetes objects,
+	// which requests can ask to check against a Kubernetes OpenAPI spec
+	// (see validate.go) or to put in apply order (see order.go)
+	objects bool
+}
+
+var (
+	evalEndpoint = jsonnetEndpoint{
+		name:     "eval",
+		op:       runJsonnet,
+		rawInput: true,
+		raw: []rawType{
+			{"application/yaml", rawText},
+			{"application/json", yaml.YAMLToJSON},
+		},
+		stream:  true,
+		objects: true,
+	}
+	formatEndpoint = jsonnetEndpoint{
+		name:     "format",
+		op:       formatJsonnet,
+		rawInput: true,
+		raw: []rawType{
+			{"application/jsonnet", rawText},
+			{"text/plain", rawText},
+		},
+	}
+	checkEndpoint = jsonnetEndpoint{
+		name:     "check",
+		op:       checkJsonnet,
+		rawInput: true,
+	}
+	// legacyEndpoint keeps the deprecated root route to the JSON envelope
+	legacyEndpoint = jsonnetEndpoint{
+		name:    "eval",
+		op:      runJsonnet,
+		objects: true,
+	}
+	// longEvalEndpoint runs jobs submitted with a job API key. Its timeout is
+	// set from the config in main.
+	longEvalEndpoint = jsonnetEndpoint{
+		name:    "eval-long",
+		op:      runJsonnet,
+		objects: true,
+	}
+)
+
+// handler evaluates the jsonnet in a JsonnetRequest.
 func handler(w http.ResponseWriter, r *http.Request) {
+	evalEndpoint.serve(w, r)
+}
+
+// formatHandler reformats the jsonnet in a JsonnetRequest.
+func formatHandler(w http.ResponseWriter, r *http.Request) {
+	formatEndpoint.serve(w, r)
+}
+
+// checkHandler checks the syntax of the jsonnet in a JsonnetRequest.
+func checkHandler(w http.ResponseWriter, r *http.Request) {
+	checkEndpoint.serve(w, r)
+}
+
+// legacyHandler evaluates jsonnet for the deprecated root route.
+func legacyHandler(w http.ResponseWriter, r *http.Request) {
+	legacyEndpoint.serve(w, r)
+}
+
+// serve runs the endpoint's op on the code in the request body.
+func (e jsonnetEndpoint) serve(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, config.MaxContentLength)
 	defer r.Body.Close()
 
-	// Set CORS headers if requested
-	if origin := r.Header.Get("Origin"); config.SkipCorsCheck || originRegexp.Match([]byte(origin)) {
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers",
-			"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token")
+	// Refuse clients that are banned or on the deny list before doing any work
+	client := clientID(r)
+	if err := clientBlocked(client); err != nil {
+		e.writeError(w, r, http.StatusForbidden, err)
+		return
 	}
 
-	// And if this is an OPTIONS request, stop here (don't process the body)
-	if r.Method == http.MethodOptions {
+	// Read the body in so we can decode it and use it as a cache key. A failure
+	// from the MaxBytesReader in this case means the request is too large.
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		abuse.record(client, abuseTooLarge)
+		e.writeError(w, r, http.StatusRequestEntityTooLarge, errTooLarge())
 		return
 	}
 
-	// Check if this text is cached... read the body in so we can use it as a
-	// cache key. A failure from the MaxBytesReader in this case means the request
-	// is too large.
-	body, err := ioutil.ReadAll(r.Body)
+	req, err := e.decode(r, body)
 	if err != nil {
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
-		w.Write([]byte(errorResponse("", fmt.Errorf("Request too large - Code must be smaller than %v bytes", config.MaxContentLength))))
+		e.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if e.wantsEventStream(r) {
+		e.serveEvents(w, r, client, req)
 		return
 	}
+	e.write(w, r, e.evaluate(r.Context(), client, req))
+}
+
+// errTooLarge is the error for code over the maximum content length.
+func errTooLarge() error {
+	return fmt.Errorf("Request too large - Code must be smaller than %v bytes", config.MaxContentLength)
+}
+
+// clientBlocked returns the error to refuse client with if it is banned or on
+// the deny list, or nil if it may go ahead.
+func clientBlocked(client string) error {
+	if denylist.clientDenied(client) {
+		p8sBlockedRequests.WithLabelValues("denylist_client").Inc()
+		return errDenied
+	}
+	if abuse.banned(client) {
+		p8sBlockedRequests.WithLabelValues("ban").Inc()
+		return errBanned
+	}
+	return nil
+}
+
+// evaluate runs the endpoint's op on behalf of client. Every transport that
+// executes jsonnet goes through here so they share the code deny list, the
+// response cache and the rate limiter.
+func (e jsonnetEndpoint) evaluate(ctx context.Context, client string, req JsonnetRequest) CachedResult {
+	if denylist.codeDenied(codeHash(req.Code)) {
+		p8sBlockedRequests.WithLabelValues("denylist_code").Inc()
+		log.Printf("Refused denied code %s from client %s", codeHash(req.Code), client)
+		return errorResult(http.StatusForbidden, errCodeDeny)
+	}
+	if req.Validate && !e.objects {
+		return errorResult(http.StatusBadRequest, fmt.Errorf("The %s endpoint can't validate its output", e.name))
+	}
+	if req.Order && !e.objects {
+		return errorResult(http.StatusBadRequest, fmt.Errorf("The %s endpoint can't order its output", e.name))
+	}
 
-	if result := codeCache.Get(string(body)); result != nil && !result.Expired() {
+	// Check if this request is cached. The key is the decoded request, so the
+	// same code gets the same entry whether it was sent raw or wrapped.
+	keyBytes, _ := json.Marshal(req)
+	cacheKey := e.name + ":" + string(keyBytes)
+	if req.Library != "" {
+		if err := validateName("library", req.Library); err != nil {
+			return errorResult(http.StatusBadRequest, err)
+		}
+		if _, err := os.Stat(filepath.Join(config.LibraryDir, req.Library)); err != nil {
+			return errorResult(http.StatusBadRequest, fmt.Errorf("No library %q", req.Library))
+		}
+		cacheKey += ":" + libraryRevision(req.Library)
+		touchLibrary(req.Library)
+	}
+	if result := codeCache.Get(cacheKey); result != nil && !result.Expired() {
 		p8sJsonnetCacheHits.Inc()
 		// Read the proper object from cache
 		if realResult, ok := result.Value().(CachedResult); ok {
-			w.WriteHeader(realResult.HTTPCode)
-			w.Write([]byte(realResult.Response))
-			return
+			replayProgress(ctx, realResult)
+			return realResult
 		}
 		//uh oh...
 		log.Printf("Could not marshal result from cache into a CachedResult: %v", result.Value())
@@ -169,85 +423,57 @@ func handler(w http.ResponseWriter, r *http.Request) {
 
 	// If it wasn't cached, throttle before calculating it. We use rate limits for
 	// the expensive part (running jsonnet). Requests that respond from cache or
-	// are too large don't count against the limit.
-	if !limiter.Allow() {
+	// are too large don't count against the limits. Only the client's own limit
+	// counts against it, since anyone can hit the global one when it's busy.
+	if !abuse.allow(client) || !limiter.Allow() {
 		p8sRateLimitedRequests.Inc()
-		w.WriteHeader(http.StatusTooManyRequests)
-		w.Write([]byte(errorResponse("", errBusy)))
-		return
+		return errorResult(http.StatusTooManyRequests, errBusy)
 	}
 
-	// Finally, generate a new cache result
+	// Finally, generate a new cache result. If the caller went away while
+	// jsonnet ran, the result is from a killed process and isn't cached, and
+	// neither are errors that might not happen again.
 	p8sJsonnetCacheMisses.Inc()
-	cachedResult := makeJsonnetCache(r.Context(), body)
-	codeCache.Set(string(body), cachedResult, 1*time.Hour)
-
-	w.WriteHeader(cachedResult.HTTPCode)
-	w.Write([]byte(cachedResult.Response))
-
-	return
-}
-
-// func newHandler(w http.ResponseWriter, r *http.Request, resp api.InitResponse) {
-// TO DO: Integrate this with init
-// 	init, _ := json.Marshal(resp)
-// 	s := string(init)
-// 	fmt.Fprintf(w, s)
-// 	// http.Serve(w, r, "init.json")
-// }
-func ksInit(w http.ResponseWriter, r *http.Request) {
-	// TODO: make sure to put the return value back api.InitResponse
-	resp := api.InitResponse{
-		AppName: "greatapp",
-		Components: map[string]interface{}{
-			"params.libsonnet": "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-		},
-		Environments: map[string]interface{}{
-			"base.libsonnet": "local components = std.extVar(\"__ksonnet/components\");\ncomponents + {\n  // Insert user-specified overrides here.\n}\n",
-			"default": map[string]interface{}{
-				"default.jsonnet":  "local base = import \"/Users/tehut/test/environments/base.libsonnet\";\nlocal k = import \"k.libsonnet\";\n\nbase + {\n  // Insert user-specified overrides here. For example if a component is named \"nginx-deployment\", you might have something like:\n  //   \"nginx-deployment\"+: k.deployment.mixin.metadata.labels({foo: \"bar\"})\n}\n",
-				"params.libsonnet": "local params = import \"/Users/tehut/test/components/params.libsonnet\";\nparams + {\n  components +: {\n    // Insert component parameter overrides here. Ex:\n    // guestbook +: {\n    //   name: \"guestbook-dev\",\n    //   replicas: params.global.replicas,\n    // },\n  },\n}\n",
-				"spec.json":        "{\n  \"server\": \"https://kubecfg-t-apiloadb-1k1vqoxu7q212-666493100.us-west-2.elb.amazonaws.com\",\n  \"namespace\": \"dev-tehut\"\n}",
-			},
-		}}
-
-	i, _ := json.Marshal(resp)
-	s := string(i)
-	fmt.Fprintf(w, s)
-	// return resp
-}
-
-func ksShow(w http.ResponseWriter, r *http.Request) {
-	resp := api.ShowResponse{
-		Components: map[string]interface{}{
-			"params.libsonnet": "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-			"ui.libsonnet":     "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-			"redis.libsonnet":  "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-		}}
-
-	show, _ := json.Marshal(resp)
-	s := string(show)
-	fmt.Fprintf(w, s)
-}
-
-func ksGenerate(w http.ResponseWriter, r *http.Request) {
-	// http.ServeFile(w, r, "generate.json")
-	resp := api.GenerateResponse{
-		Components: map[string]interface{}{
-			"params.libsonnet": "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-			"ui.libsonnet":     "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-			"redis.libsonnet":  "{\n  global: {\n    // User-defined global parameters; accessible to all component and environments, Ex:\n    // replicas: 4,\n  },\n  components: {\n    // Component-level parameters, defined initially from 'ks prototype use ...'\n    // Each object below should correspond to a component in the components/ directory\n  },\n}\n",
-		}}
-
-	gen, _ := json.Marshal(resp)
-	s := string(gen)
-	fmt.Fprintf(w, s)
+	reportProgress(ctx, StreamEvent{Type: StreamStarted})
+	runCtx := ctx
+	if e.timeout != 0 {
+		runCtx = withRunTimeout(ctx, e.timeout)
+	}
+	if req.Library != "" {
+		runCtx = withImportPath(runCtx, filepath.Join(config.LibraryDir, req.Library))
+	}
+	cachedResult := makeJsonnetCache(runCtx, e.op, req)
+	if req.Order {
+		cachedResult = orderResult(cachedResult)
+	}
+	if req.Validate {
+		cachedResult = validateResult(cachedResult, req.K8sVersion)
+	}
+	if ctx.Err() == nil && !cachedResult.Response.Retryable {
+		codeCache.Set(cacheKey, cachedResult, 1*time.Hour)
+	}
+	if output := cachedResult.Response.Output; output != nil {
+		reportProgress(ctx, StreamEvent{Type: StreamOutput, Bytes: len(*output)})
+	}
+	// A deadline the caller set itself isn't held against it.
+	if cachedResult.Response.Code == CodeTimeout && ctx.Err() == nil {
+		log.Printf("Client %s timed out evaluating code %s", client, codeHash(req.Code))
+		abuse.record(client, abuseTimeout)
+	}
+	return cachedResult
 }
 
 func main() {
 
 	limiter = rate.NewLimiter(config.RateLimit, config.RateLimitBurst)
 	codeCache = ccache.New(ccache.Configure().MaxSize(config.CacheSize))
+	abuse = newAbuseTracker()
+	jobs = newJobStore()
+	workspaces = newWorkspaceStore()
+	longEvalEndpoint.timeout = config.JobRunTimeout
+	if config.LibraryIdle > 0 {
+		go expireLibrariesEvery(time.Minute)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -256,17 +482,7 @@ func main() {
 		// Host the main site on 8080
 		defer wg.Done()
 
-		mux := http.NewServeMux()
-
-		p8sHandlerChain := promhttp.InstrumentHandlerInFlight(p8sRunningRequests,
-			promhttp.InstrumentHandlerCounter(p8sRequests,
-				promhttp.InstrumentHandlerDuration(p8sRequestDuration, http.HandlerFunc(handler)),
-			),
-		)
-
-		mux.Handle("/", p8sHandlerChain)
-		mux.HandleFunc("/show", ksShow)
-		mux.HandleFunc("/generate", ksGenerate)
+		mux := newAPIMux()
 		log.Println("Starting main server at :8080")
 		err := http.ListenAndServe(":8080", mux)
 
@@ -277,11 +493,14 @@ func main() {
 	}()
 
 	go func() {
-		// Host metrics on 9102 (so that we don't expose /metrics to the internet)
+		// Host metrics and admin endpoints on 9102 (so that we don't expose them
+		// to the internet)
 		defer wg.Done()
 
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", promhttp.Handler())
+		mux.HandleFunc("/admin/denylist", denyListHandler)
+		mux.HandleFunc("/admin/libraries/", adminLibraryHandler)
 		log.Println("Starting metrics server at :9102")
 		err := http.ListenAndServe(":9102", mux)
 
@@ -290,6 +509,29 @@ func main() {
 		}
 	}()
 
+	if config.GRPCPort != 0 {
+		wg.Add(1)
+		go func() {
+			// Host the gRPC service on its own port, since it needs HTTP/2
+			defer wg.Done()
+
+			server, err := newGRPCServer()
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			if server.TLSConfig != nil {
+				log.Printf("Starting gRPC server at %s with a self-signed certificate", server.Addr)
+			} else {
+				log.Printf("Starting gRPC server at %s", server.Addr)
+			}
+			err = server.ListenAndServeTLS(config.GRPCTLSCert, config.GRPCTLSKey)
+
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+		}()
+	}
+
 	wg.Wait()
 	log.Print("Graceful Exit...")
 }
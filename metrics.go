@@ -45,6 +45,45 @@ var (
 		Name: "ksonnetplayground_jsonnet_cache_misses",
 		Help: "Number of requests to the ksonnet playground API where the input jsonnet code is a cache miss",
 	})
+
+	p8sLiveSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ksonnetplayground_live_sessions",
+		Help: "Number of live evaluation WebSocket sessions currently open on this instance",
+	})
+
+	p8sRunningJobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ksonnetplayground_running_jobs",
+		Help: "Number of evaluation jobs currently running on this instance",
+	})
+
+	p8sWorkspaces = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ksonnetplayground_workspaces",
+		Help: "Number of app workspaces currently kept by this instance",
+	})
+
+	p8sAbuseEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ksonnetplayground_abuse_events",
+			Help: "Number of requests to the ksonnet playground that counted against a client's abuse thresholds",
+		},
+		[]string{"kind"},
+	)
+
+	p8sClientBans = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ksonnetplayground_client_bans",
+			Help: "Number of temporary client bans issued by the ksonnet playground, by the threshold that was crossed",
+		},
+		[]string{"kind"},
+	)
+
+	p8sBlockedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ksonnetplayground_requests_blocked",
+			Help: "Number of requests to the ksonnet playground refused because of a ban or the deny list",
+		},
+		[]string{"reason"},
+	)
 )
 
 func init() {
@@ -56,5 +95,11 @@ func init() {
 		p8sRunningRequests,
 		p8sJsonnetCacheHits,
 		p8sJsonnetCacheMisses,
+		p8sLiveSessions,
+		p8sRunningJobs,
+		p8sWorkspaces,
+		p8sAbuseEvents,
+		p8sClientBans,
+		p8sBlockedRequests,
 	)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rawType is a media type that an endpoint can answer with instead of the
+// JSON envelope, along with the conversion of the op's output into it.
+type rawType struct {
+	mediaType string
+	convert   func(output []byte) ([]byte, error)
+}
+
+// rawText passes output through unchanged.
+func rawText(output []byte) ([]byte, error) {
+	return output, nil
+}
+
+// Problem is an RFC 7807 problem details object, used for errors sent to
+// clients that asked for raw output. Code and Retryable extend it with the
+// same fields as JsonnetResponse.
+type Problem struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail"`
+	Code      ErrorCode `json:"code"`
+	Retryable bool      `json:"retryable"`
+}
+
+const problemMediaType = "application/problem+json"
+
+// isRawInput reports whether r carries bare jsonnet rather than a
+// JsonnetRequest. Anything but these content types is decoded as JSON, since
+// older clients post the envelope with whatever curl or the browser picks.
+func (e jsonnetEndpoint) isRawInput(r *http.Request) bool {
+	if !e.rawInput {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/jsonnet" || mediaType == "text/plain"
+}
+
+// decode turns the request body into a JsonnetRequest.
+func (e jsonnetEndpoint) decode(r *http.Request, body []byte) (JsonnetRequest, error) {
+	var req JsonnetRequest
+	if e.isRawInput(r) {
+		req.Code = string(body)
+		return req, nil
+	}
+	err := json.Unmarshal(body, &req)
+	return req, err
+}
+
+// acceptedTypes returns the media ranges of the Accept header, most preferred
+// first. A missing header accepts anything.
+func acceptedTypes(r *http.Request) []string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	types := make([]string, 0, len(ranges))
+	for _, mr := range ranges {
+		types = append(types, mr.mediaType)
+	}
+	if len(types) == 0 {
+		types = append(types, "*/*")
+	}
+	return types
+}
+
+// responseType picks the raw type to answer r with, or nil for the JSON
+// envelope. Clients that sent raw code get raw output unless they ask for a
+// specific type we don't have.
+func (e jsonnetEndpoint) responseType(r *http.Request) *rawType {
+	if len(e.raw) == 0 {
+		return nil
+	}
+	for _, accepted := range acceptedTypes(r) {
+		for i := range e.raw {
+			if e.raw[i].mediaType == accepted {
+				return &e.raw[i]
+			}
+		}
+		if accepted == "*/*" || accepted == "application/*" {
+			if e.isRawInput(r) {
+				return &e.raw[0]
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// wantsProblem reports whether errors for r should be sent as problem
+// details rather than in the JSON envelope.
+func (e jsonnetEndpoint) wantsProblem(r *http.Request) bool {
+	if !e.rawInput {
+		return false
+	}
+	if e.responseType(r) != nil || e.isRawInput(r) {
+		return true
+	}
+	for _, accepted := range acceptedTypes(r) {
+		if accepted == problemMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// write sends result in the format negotiated with the client.
+func (e jsonnetEndpoint) write(w http.ResponseWriter, r *http.Request, result CachedResult) {
+	if result.Response.Error != nil {
+		if e.wantsProblem(r) {
+			writeProblem(w, result.HTTPCode, result.Response)
+			return
+		}
+	} else if raw := e.responseType(r); raw != nil {
+		body, err := raw.convert([]byte(*result.Response.Output))
+		if err != nil {
+			writeProblem(w, http.StatusInternalServerError, newErrorResponse(CodeInternal, "", err))
+			return
+		}
+		w.Header().Set("Content-Type", raw.mediaType)
+		w.WriteHeader(result.HTTPCode)
+		w.Write(body)
+		return
+	}
+
+	bytes, err := json.Marshal(result.Response)
+	if err != nil {
+		log.Fatalf("Failed to serialize JSON response:\n%v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(result.HTTPCode)
+	w.Write(bytes)
+}
+
+// writeError sends err in the format negotiated with the client.
+func (e jsonnetEndpoint) writeError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	e.write(w, r, errorResult(code, err))
+}
+
+// writeProblem sends the error in res as problem details.
+func writeProblem(w http.ResponseWriter, code int, res JsonnetResponse) {
+	bytes, err := json.Marshal(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    strings.TrimSpace(*res.Error),
+		Code:      res.Code,
+		Retryable: res.Retryable,
+	})
+	if err != nil {
+		log.Fatalf("Failed to serialize problem JSON response:\n%v", err)
+	}
+	w.Header().Set("Content-Type", problemMediaType)
+	w.WriteHeader(code)
+	w.Write(bytes)
+}
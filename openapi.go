@@ -0,0 +1,766 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+// apiDoc describes an API operation for the OpenAPI spec. Request and
+// Response are zero values of the Go types of the request and response
+// bodies; a nil Request means the operation takes no body. RequestMedia and
+// ResponseMedia list the media types of binary bodies, such as archives, in
+// place of Request and Response. Status is the success status, if not 200.
+// Query maps query parameters to their descriptions. Endpoint is set for
+// operations that negotiate raw input and output. For operations that switch
+// to a WebSocket, Request and Response are the message types.
+type apiDoc struct {
+	Summary       string
+	Request       interface{}
+	Response      interface{}
+	RequestMedia  []string
+	ResponseMedia []string
+	Status        int
+	Query         map[string]string
+	Endpoint      *jsonnetEndpoint
+	WebSocket     bool
+}
+
+// apiDocs documents the operations served by apiRoutes, keyed by method and
+// path. Routed operations missing from here still show up in the spec, but
+// without schemas, which the CI check in test-files/openapi.sh rejects.
+var apiDocs = map[string]apiDoc{
+	"POST /eval": {
+		Summary:  "Evaluate jsonnet code and return the output as YAML",
+		Request:  JsonnetRequest{},
+		Response: JsonnetResponse{},
+		Endpoint: &evalEndpoint,
+	},
+	"POST /batch": {
+		Summary:  "Evaluate several pieces of jsonnet code in one request",
+		Request:  BatchRequest{},
+		Response: BatchResponse{},
+	},
+	"POST /format": {
+		Summary:  "Reformat jsonnet code",
+		Request:  JsonnetRequest{},
+		Response: JsonnetResponse{},
+		Endpoint: &formatEndpoint,
+	},
+	"POST /check": {
+		Summary:  "Check that jsonnet code parses, without evaluating it",
+		Request:  JsonnetRequest{},
+		Response: JsonnetResponse{},
+		Endpoint: &checkEndpoint,
+	},
+	"POST /jobs": {
+		Summary:  "Start evaluating jsonnet code in the background",
+		Request:  JsonnetRequest{},
+		Response: Job{},
+		Status:   http.StatusAccepted,
+	},
+	"GET /jobs/{id}": {
+		Summary:  "Poll a job for its status and result",
+		Response: Job{},
+	},
+	"DELETE /jobs/{id}": {
+		Summary:  "Cancel a job",
+		Response: Job{},
+	},
+	"GET /live": {
+		Summary:   "Stream code edits over a WebSocket and receive evaluation results",
+		Request:   LiveRequest{},
+		Response:  LiveResponse{},
+		WebSocket: true,
+	},
+	"GET /libraries": {
+		Summary:  "List the library versions that code can import",
+		Response: LibrariesResponse{},
+	},
+	"POST /libraries": {
+		Summary:  "Generate a library with builders from a Kubernetes OpenAPI spec or CustomResourceDefinitions",
+		Request:  GenerateLibraryRequest{},
+		Response: Library{},
+		Status:   http.StatusCreated,
+	},
+	"POST /convert": {
+		Summary:  "Convert Kubernetes YAML into jsonnet built with the builders of a library",
+		Request:  ConvertRequest{},
+		Response: ConvertResponse{},
+	},
+	"DELETE /libraries/{name}": {
+		Summary:  "Delete a generated library",
+		Response: Library{},
+	},
+	"POST /init": {
+		Summary:  "Scaffold a ksonnet app",
+		Request:  api.InitRequest{},
+		Response: api.InitResponse{},
+	},
+	"POST /show": {
+		Summary:  "Render the components of a ksonnet app",
+		Request:  api.ShowRequest{},
+		Response: api.ShowResponse{},
+	},
+	"POST /generate": {
+		Summary:  "Generate a component from a prototype",
+		Request:  api.GenerateRequest{},
+		Response: api.GenerateResponse{},
+	},
+	"POST /env/list": {
+		Summary:  "List the environments of a ksonnet app",
+		Request:  api.EnvRequest{},
+		Response: api.EnvListResponse{},
+	},
+	"POST /env/add": {
+		Summary:  "Add an environment to a ksonnet app",
+		Request:  api.EnvRequest{},
+		Response: api.InitResponse{},
+	},
+	"POST /env/set": {
+		Summary:  "Change the server, namespace or name of an environment of a ksonnet app",
+		Request:  api.EnvRequest{},
+		Response: api.InitResponse{},
+	},
+	"POST /env/rm": {
+		Summary:  "Remove an environment from a ksonnet app",
+		Request:  api.EnvRequest{},
+		Response: api.InitResponse{},
+	},
+	"POST /param/list": {
+		Summary:  "List the effective component parameters of an environment of a ksonnet app",
+		Request:  api.ParamRequest{},
+		Response: api.ParamListResponse{},
+	},
+	"POST /param/set": {
+		Summary:  "Set a component parameter of a ksonnet app, globally or for an environment",
+		Request:  api.ParamRequest{},
+		Response: api.InitResponse{},
+	},
+	"POST /param/unset": {
+		Summary:  "Remove a component parameter of a ksonnet app, globally or for an environment",
+		Request:  api.ParamRequest{},
+		Response: api.InitResponse{},
+	},
+	"POST /param/diff": {
+		Summary:  "Compare the component parameters of two environments of a ksonnet app",
+		Request:  api.ParamRequest{},
+		Response: api.ParamDiffResponse{},
+	},
+	"POST /diff": {
+		Summary:  "Compare the objects a ksonnet app renders for two environments",
+		Request:  api.DiffRequest{},
+		Response: api.DiffResponse{},
+	},
+	"POST /export": {
+		Summary:       "Download a ksonnet app as an archive laid out for the ks CLI",
+		Request:       api.InitResponse{},
+		ResponseMedia: []string{archiveMediaTypes[archiveTarGz], archiveMediaTypes[archiveZip]},
+		Query:         map[string]string{"format": "Archive format, tar.gz (the default) or zip"},
+	},
+	"POST /apply/preview": {
+		Summary:  "Preview the patches kubectl apply would send to a cluster with the given live objects",
+		Request:  ApplyPreviewRequest{},
+		Response: ApplyPreviewResponse{},
+	},
+	"POST /workspaces": {
+		Summary:  "Scaffold a ksonnet app and keep it in a new workspace",
+		Request:  api.InitRequest{},
+		Response: Workspace{},
+		Status:   http.StatusCreated,
+	},
+	"GET /workspaces/{id}": {
+		Summary:  "Get a workspace and its app",
+		Response: Workspace{},
+	},
+	"DELETE /workspaces/{id}": {
+		Summary:  "Delete a workspace",
+		Response: Workspace{},
+	},
+	"GET /workspaces/{id}/files": {
+		Summary:  "Read a file of a workspace",
+		Response: WorkspaceFile{},
+		Query:    map[string]string{"path": "Path of the file in the app, e.g. components/guestbook.jsonnet"},
+	},
+	"PUT /workspaces/{id}/files": {
+		Summary:  "Write a file of a workspace, making a new revision",
+		Request:  WorkspaceFile{},
+		Response: Workspace{},
+		Query:    map[string]string{"path": "Path of the file in the app, e.g. components/guestbook.jsonnet"},
+	},
+	"DELETE /workspaces/{id}/files": {
+		Summary:  "Delete a file or directory of a workspace, making a new revision",
+		Response: Workspace{},
+		Query:    map[string]string{"path": "Path of the file in the app, e.g. components/guestbook.jsonnet"},
+	},
+	"POST /workspaces/{id}/rename": {
+		Summary:  "Rename a component of a workspace and its parameters, making a new revision",
+		Request:  RenameRequest{},
+		Response: Workspace{},
+	},
+	"POST /workspaces/{id}/show": {
+		Summary:  "Render the components of a workspace for an environment",
+		Request:  WorkspaceShowRequest{},
+		Response: api.ShowResponse{},
+	},
+	"GET /workspaces/{id}/revisions": {
+		Summary:  "List the revisions of a workspace",
+		Response: RevisionsResponse{},
+	},
+	"GET /workspaces/{id}/diff": {
+		Summary:  "Compare the files of two revisions of a workspace",
+		Response: RevisionDiffResponse{},
+		Query: map[string]string{
+			"from": "Revision to compare from, by default the one before the current one",
+			"to":   "Revision to compare to, by default the current one",
+		},
+	},
+	"POST /workspaces/{id}/rollback": {
+		Summary:  "Make a new revision of a workspace with the app of an earlier one",
+		Request:  RollbackRequest{},
+		Response: Workspace{},
+	},
+	"GET /workspaces/{id}/archive": {
+		Summary:       "Download the app of a workspace as an archive laid out for the ks CLI",
+		ResponseMedia: []string{archiveMediaTypes[archiveTarGz], archiveMediaTypes[archiveZip]},
+		Query:         map[string]string{"format": "Archive format, tar.gz (the default) or zip"},
+	},
+	"GET /workspaces/{id}/env/list": {
+		Summary:  "List the environments of a workspace",
+		Response: api.EnvListResponse{},
+	},
+	"POST /workspaces/{id}/env/add": {
+		Summary:  "Add an environment to a workspace, making a new revision",
+		Request:  WorkspaceEnvRequest{},
+		Response: Workspace{},
+	},
+	"POST /workspaces/{id}/env/set": {
+		Summary:  "Change the server, namespace or name of an environment of a workspace, making a new revision",
+		Request:  WorkspaceEnvRequest{},
+		Response: Workspace{},
+	},
+	"POST /workspaces/{id}/env/rm": {
+		Summary:  "Remove an environment from a workspace, making a new revision",
+		Request:  WorkspaceEnvRequest{},
+		Response: Workspace{},
+	},
+	"POST /workspaces/import": {
+		Summary:      "Keep the ks app in an uploaded tar.gz or zip archive as a new workspace",
+		RequestMedia: []string{archiveMediaTypes[archiveTarGz], archiveMediaTypes[archiveZip]},
+		Response:     Workspace{},
+		Status:       http.StatusCreated,
+	},
+	"GET /prototypes": {
+		Summary:  "List the prototypes components can be generated from",
+		Response: PrototypesResponse{},
+		Query:    map[string]string{"q": "Only list prototypes whose name or description contains each of these words"},
+	},
+	"GET /prototypes/{name}": {
+		Summary:  "Describe a prototype and its parameters",
+		Response: PrototypeResponse{},
+	},
+	"GET /openapi.json": {
+		Summary:  "This OpenAPI document",
+		Response: map[string]interface{}{},
+	},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// openAPISpec builds an OpenAPI 3 document for the routes in apiRoutes,
+// deriving the schemas from the Go types in apiDocs. Routes are walked in
+// order, so the document is the same every time.
+func openAPISpec() map[string]interface{} {
+	routes := apiRoutes()
+	var routePaths []string
+	var inputs, outputs []reflect.Type
+	for path, m := range routes {
+		routePaths = append(routePaths, path)
+		for method := range m {
+			doc := apiDocs[method+" "+path]
+			if doc.Request != nil {
+				inputs = append(inputs, reflect.TypeOf(doc.Request))
+			}
+			if doc.Response != nil {
+				outputs = append(outputs, reflect.TypeOf(doc.Response))
+			}
+		}
+	}
+	sort.Strings(routePaths)
+	outputs = append(outputs, reflect.TypeOf(JsonnetResponse{}), reflect.TypeOf(Problem{}), reflect.TypeOf(StreamEvent{}))
+	schemas := newSchemaSet(inputs, outputs)
+	errorSchema := schemaFor(reflect.TypeOf(JsonnetResponse{}), schemas, true)
+
+	paths := map[string]interface{}{}
+	for _, path := range routePaths {
+		var routeMethods []string
+		for method := range routes[path] {
+			routeMethods = append(routeMethods, method)
+		}
+		sort.Strings(routeMethods)
+
+		ops := map[string]interface{}{}
+		for _, method := range routeMethods {
+			doc := apiDocs[method+" "+path]
+			op := map[string]interface{}{
+				"operationId": operationID(method, path),
+				"summary":     doc.Summary,
+				"responses": map[string]interface{}{
+					"default": jsonContent("Error", errorSchema),
+				},
+			}
+			if params := append(pathParameters(path), queryParameters(doc.Query)...); len(params) > 0 {
+				op["parameters"] = params
+			}
+			if doc.WebSocket {
+				addWebSocket(op, doc, schemas)
+				ops[strings.ToLower(method)] = op
+				continue
+			}
+			if doc.Request != nil {
+				op["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content":  jsonContent("", schemaFor(reflect.TypeOf(doc.Request), schemas, false))["content"],
+				}
+			}
+			if doc.RequestMedia != nil {
+				op["requestBody"] = map[string]interface{}{
+					"required": true,
+					"content":  binaryContent("", doc.RequestMedia)["content"],
+				}
+			}
+			status := doc.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if doc.Response != nil {
+				op["responses"].(map[string]interface{})[strconv.Itoa(status)] =
+					jsonContent("Success", schemaFor(reflect.TypeOf(doc.Response), schemas, true))
+			}
+			if doc.ResponseMedia != nil {
+				op["responses"].(map[string]interface{})[strconv.Itoa(status)] = binaryContent("Success", doc.ResponseMedia)
+			}
+			if doc.Endpoint != nil {
+				addRawContent(op, doc.Endpoint, schemaFor(reflect.TypeOf(Problem{}), schemas, true),
+					schemaFor(reflect.TypeOf(StreamEvent{}), schemas, true))
+			}
+			ops[strings.ToLower(method)] = op
+		}
+		paths[path] = ops
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ksonnet playground API",
+			"version": strings.TrimPrefix(apiPrefix, "/api/"),
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": apiPrefix}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas.schemas},
+	}
+}
+
+// pathParameters documents the {name} segments of path.
+func pathParameters(path string) []interface{} {
+	var params []interface{}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+// queryParameters documents the query parameters of an operation, sorted by
+// name.
+func queryParameters(query map[string]string) []interface{} {
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []interface{}
+	for _, name := range names {
+		params = append(params, map[string]interface{}{
+			"name":        name,
+			"in":          "query",
+			"description": query[name],
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	return params
+}
+
+// operationID turns e.g. "GET /openapi.json" into "getOpenapiJson".
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// addRawContent documents the raw media types negotiated by e on op. The
+// bare JSON output shares application/json with the envelope, so it's only
+// mentioned in the description. The data of server-sent events is described
+// by the x-event-data extension, since OpenAPI can't.
+func addRawContent(op map[string]interface{}, e *jsonnetEndpoint, problemSchema, eventSchema interface{}) {
+	text := map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	if e.rawInput {
+		content := op["requestBody"].(map[string]interface{})["content"].(map[string]interface{})
+		content["application/jsonnet"] = text
+		content["text/plain"] = text
+	}
+
+	responses := op["responses"].(map[string]interface{})
+	success := responses["200"].(map[string]interface{})
+	for _, raw := range e.raw {
+		if raw.mediaType == "application/json" {
+			success["description"] = "Success. Send `Accept: application/json` to get the bare JSON output instead of this envelope."
+			continue
+		}
+		success["content"].(map[string]interface{})[raw.mediaType] = text
+	}
+	if e.stream {
+		success["content"].(map[string]interface{})[eventStreamMediaType] = map[string]interface{}{
+			"schema":       map[string]interface{}{"type": "string"},
+			"x-event-data": eventSchema,
+		}
+	}
+	responses["default"].(map[string]interface{})["content"].(map[string]interface{})[problemMediaType] =
+		map[string]interface{}{"schema": problemSchema}
+}
+
+// addWebSocket documents an operation that switches to a WebSocket. OpenAPI
+// can't describe the messages, so their schemas are referenced from the
+// description and the x-websocket-messages extension.
+func addWebSocket(op map[string]interface{}, doc apiDoc, schemas *schemaSet) {
+	client := schemaFor(reflect.TypeOf(doc.Request), schemas, false)
+	server := schemaFor(reflect.TypeOf(doc.Response), schemas, true)
+	op["responses"].(map[string]interface{})["101"] = map[string]interface{}{
+		"description": "Switching to the WebSocket protocol. The client sends " +
+			reflect.TypeOf(doc.Request).Name() + " messages and the server answers with " +
+			reflect.TypeOf(doc.Response).Name() + " messages.",
+	}
+	op["x-websocket-messages"] = map[string]interface{}{
+		"client": client,
+		"server": server,
+	}
+}
+
+func jsonContent(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// binaryContent documents a body that's a file of one of the given media
+// types.
+func binaryContent(description string, mediaTypes []string) map[string]interface{} {
+	content := map[string]interface{}{}
+	for _, mediaType := range mediaTypes {
+		content[mediaType] = map[string]interface{}{
+			"schema": map[string]interface{}{"type": "string", "format": "binary"},
+		}
+	}
+	return map[string]interface{}{
+		"description": description,
+		"content":     content,
+	}
+}
+
+// schemaSet holds the component schemas of a spec, with the names of the
+// named struct types they're for. Types used both in requests and in
+// responses get a schema for each, since only the output one has required
+// fields, and the input one is named with an Input suffix.
+type schemaSet struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+	both    map[reflect.Type]bool
+}
+
+// newSchemaSet names the named struct types used by the inputs and outputs
+// of a spec. Types are named after themselves, and those whose name is taken
+// by a type in the main package or one sorting before them are prefixed with
+// the name of their package.
+func newSchemaSet(inputs, outputs []reflect.Type) *schemaSet {
+	in, out := map[reflect.Type]bool{}, map[reflect.Type]bool{}
+	for _, t := range inputs {
+		namedStructs(t, in)
+	}
+	for _, t := range outputs {
+		namedStructs(t, out)
+	}
+
+	set := &schemaSet{schemas: map[string]interface{}{}, names: map[reflect.Type]string{}, both: map[reflect.Type]bool{}}
+	var types []reflect.Type
+	for t := range in {
+		types = append(types, t)
+		set.both[t] = out[t]
+	}
+	for t := range out {
+		if !in[t] {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		a, b := types[i], types[j]
+		if (a.PkgPath() == "main") != (b.PkgPath() == "main") {
+			return a.PkgPath() == "main"
+		}
+		if a.Name() != b.Name() {
+			return a.Name() < b.Name()
+		}
+		return a.PkgPath() < b.PkgPath()
+	})
+	taken := map[string]bool{}
+	for _, t := range types {
+		name := t.Name()
+		if taken[name] {
+			name = strings.Title(path.Base(t.PkgPath())) + name
+		}
+		taken[name] = true
+		set.names[t] = name
+	}
+	return set
+}
+
+// namedStructs adds the named struct types t is made of, including itself,
+// to types.
+func namedStructs(t reflect.Type, types map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		namedStructs(t.Elem(), types)
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) || types[t] {
+			return
+		}
+		if t.Name() != "" {
+			types[t] = true
+		}
+		for _, field := range jsonFields(t) {
+			namedStructs(field.typ, types)
+		}
+	}
+}
+
+// schemaFor returns the JSON schema for t. Named struct types are added to
+// schemas and referenced, so each shows up once in the spec. Fields without
+// omitempty are always serialized, so they're marked required in output
+// types; encoding/json doesn't require any field of an input.
+func schemaFor(t reflect.Type, schemas *schemaSet, output bool) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := schemaFor(t.Elem(), schemas, output)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		if e, ok := reflect.Zero(t).Interface().(interface {
+			enum() []string
+		}); ok {
+			return map[string]interface{}{"type": "string", "enum": e.enum()}
+		}
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		// nil slices and maps are serialized as null
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte", "nullable": true}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas, output), "nullable": true}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas, output)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas, output), "nullable": true}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas, output)
+		}
+		name, ok := schemas.names[t]
+		if !ok {
+			log.Printf("No OpenAPI schema name for %v", t)
+			return structSchema(t, schemas, output)
+		}
+		if !output && schemas.both[t] {
+			name += "Input"
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, done := schemas.schemas[name]; done {
+			return ref
+		}
+		// Placeholder so recursive types terminate
+		schemas.schemas[name] = nil
+		schemas.schemas[name] = structSchema(t, schemas, output)
+		return ref
+	}
+
+	log.Printf("No OpenAPI schema for %v", t)
+	return map[string]interface{}{}
+}
+
+// structSchema returns the JSON schema of the fields of the struct type t.
+func structSchema(t reflect.Type, schemas *schemaSet, output bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, field := range jsonFields(t) {
+		properties[field.name] = schemaFor(field.typ, schemas, output)
+		if output && !field.omitempty {
+			required = append(required, field.name)
+		}
+	}
+	sort.Strings(required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// jsonField is a field encoding/json serializes, found depth embedded
+// structs down.
+type jsonField struct {
+	name      string
+	omitempty bool
+	tagged    bool
+	depth     int
+	typ       reflect.Type
+}
+
+// jsonFields returns the fields encoding/json serializes for the struct type
+// t, promoting those of embedded structs the same way: of the fields with
+// the same name, the least deeply embedded one wins, or the only tagged one
+// among those, and if that leaves more than one none is serialized.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	onPath := map[reflect.Type]bool{}
+	var collect func(t reflect.Type, depth int)
+	collect = func(t reflect.Type, depth int) {
+		if onPath[t] {
+			return
+		}
+		onPath[t] = true
+		defer delete(onPath, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")[0]
+			if field.Anonymous && tag == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					collect(embedded, depth+1)
+					continue
+				}
+			}
+			name, omitempty := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+			fields = append(fields, jsonField{name, omitempty, tag != "", depth, field.Type})
+		}
+	}
+	collect(t, 0)
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.depth != b.depth {
+			return a.depth < b.depth
+		}
+		return a.tagged && !b.tagged
+	})
+	var dominant []jsonField
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].name == fields[i].name {
+			j++
+		}
+		// The candidates are the least deep fields, and among those the
+		// tagged ones if there are any, which sort first
+		n := 1
+		for n < j-i && fields[i+n].depth == fields[i].depth && fields[i+n].tagged == fields[i].tagged {
+			n++
+		}
+		if n == 1 {
+			dominant = append(dominant, fields[i])
+		}
+		i = j
+	}
+	return dominant
+}
+
+// jsonFieldName returns the name encoding/json uses for field, or "" if the
+// field isn't serialized.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := strings.Split(field.Tag.Get("json"), ",")
+	if tag[0] == "-" {
+		return "", false
+	}
+	name := tag[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitempty := false
+	for _, opt := range tag[1:] {
+		omitempty = omitempty || opt == "omitempty"
+	}
+	return name, omitempty
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		var err error
+		openAPIJSON, err = json.MarshalIndent(openAPISpec(), "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to serialize OpenAPI spec:\n%v", err)
+		}
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
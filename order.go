@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/ghodss/yaml"
+)
+
+// OrderedObject is an object of ordered output, with the reason it's where
+// it is.
+type OrderedObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// applyTier is a group of kinds that are applied together, after those of
+// the tiers before it.
+type applyTier struct {
+	kinds  []string
+	reason string
+}
+
+// applyTiers are the kinds in the order they're applied: what other objects
+// live in or are defined by first, then what workloads use, the workloads,
+// and what refers to them. Within a tier, kinds are applied in the order
+// they're listed in. Custom resources and unknown kinds come last.
+var applyTiers = []applyTier{
+	{[]string{"Namespace"}, "Namespaces go first so the objects in them can be created"},
+	{[]string{"CustomResourceDefinition"}, "CustomResourceDefinitions go before the custom resources of their kinds"},
+	{
+		[]string{"PodSecurityPolicy", "ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding"},
+		"Service accounts and RBAC rules go before the workloads that run with them",
+	},
+	{
+		[]string{"ResourceQuota", "LimitRange", "PriorityClass", "StorageClass", "PersistentVolume", "PersistentVolumeClaim", "Secret", "ConfigMap"},
+		"Configuration and storage go before the workloads that use them",
+	},
+	{[]string{"Service", "Endpoints"}, "Services go before workloads so their pods can find them"},
+	{
+		[]string{"Pod", "ReplicationController", "ReplicaSet", "Deployment", "DaemonSet", "StatefulSet", "Job", "CronJob"},
+		"Workloads go once what they depend on exists",
+	},
+	{
+		[]string{"HorizontalPodAutoscaler", "PodDisruptionBudget", "NetworkPolicy", "Ingress", "APIService"},
+		"Objects that refer to workloads and services go after them",
+	},
+}
+
+// applyRank is the position of an object's kind in the apply order: its tier,
+// and its kind's place in the tier.
+type applyRank struct {
+	tier, kind int
+	reason     string
+}
+
+// orderObjects sorts objects, which flattenObjects found, into an order they
+// can be applied in, keeping the order they had within each kind.
+func orderObjects(objects []interface{}) ([]interface{}, []OrderedObject) {
+	ranks := map[string]applyRank{}
+	for t, tier := range applyTiers {
+		for k, kind := range tier.kinds {
+			ranks[kind] = applyRank{t, k, tier.reason}
+		}
+	}
+
+	// Custom resources are recognized by the CRDs among the objects
+	crds := map[string]string{}
+	for _, object := range objects {
+		o, _ := object.(map[string]interface{})
+		if o["kind"] != "CustomResourceDefinition" {
+			continue
+		}
+		metadata, _ := o["metadata"].(map[string]interface{})
+		spec, _ := o["spec"].(map[string]interface{})
+		names, _ := spec["names"].(map[string]interface{})
+		group, _ := spec["group"].(string)
+		kind, _ := names["kind"].(string)
+		name, _ := metadata["name"].(string)
+		crds[group+"/"+kind] = name
+	}
+
+	type entry struct {
+		object interface{}
+		meta   OrderedObject
+		rank   applyRank
+	}
+	entries := make([]entry, len(objects))
+	for i, object := range objects {
+		o, _ := object.(map[string]interface{})
+		metadata, _ := o["metadata"].(map[string]interface{})
+		var meta OrderedObject
+		meta.APIVersion, _ = o["apiVersion"].(string)
+		meta.Kind, _ = o["kind"].(string)
+		meta.Namespace, _ = metadata["namespace"].(string)
+		meta.Name, _ = metadata["name"].(string)
+
+		rank, known := ranks[meta.Kind]
+		if !known || !isBuiltIn(apiGroup(meta.APIVersion)) {
+			rank = applyRank{tier: len(applyTiers)}
+			if crd, ok := crds[apiGroup(meta.APIVersion)+"/"+meta.Kind]; ok {
+				rank.reason = fmt.Sprintf("Custom resources go after their CustomResourceDefinition, %s, and the workloads that may serve them", crd)
+			} else {
+				rank.tier++
+				rank.reason = fmt.Sprintf("%s isn't a known kind, so it goes last", meta.Kind)
+			}
+		}
+		meta.Reason = rank.reason
+		entries[i] = entry{object, meta, rank}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].rank, entries[j].rank
+		if a.tier != b.tier {
+			return a.tier < b.tier
+		}
+		return a.kind < b.kind
+	})
+	ordered := make([]interface{}, len(entries))
+	order := make([]OrderedObject, len(entries))
+	for i, e := range entries {
+		ordered[i], order[i] = e.object, e.meta
+	}
+	return ordered, order
+}
+
+// orderResult replaces the YAML output of a successful result with a List
+// of the Kubernetes objects in it in apply order, giving the reason for the
+// position of each in the response.
+func orderResult(result CachedResult) CachedResult {
+	if result.Response.Output == nil {
+		return result
+	}
+	failed := func(status int, err error) CachedResult {
+		failed := errorResult(status, err)
+		failed.Response.Logs = result.Response.Logs
+		return failed
+	}
+
+	output, err := yaml.YAMLToJSON([]byte(*result.Response.Output))
+	var value interface{}
+	if err == nil {
+		err = json.Unmarshal(output, &value)
+	}
+	if err != nil {
+		return failed(http.StatusInternalServerError, err)
+	}
+	objects, err := flattenObjects(value)
+	if err != nil {
+		return failed(http.StatusBadRequest, fmt.Errorf("Can't order the output: %v", err))
+	}
+
+	ordered, order := orderObjects(objects)
+	list, err := json.Marshal(map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": ordered})
+	if err == nil {
+		list, err = yaml.JSONToYAML(list)
+	}
+	if err != nil {
+		return failed(http.StatusInternalServerError, err)
+	}
+	orderedOutput := string(list)
+	result.Response.Output = &orderedOutput
+	result.Response.Order = order
+	return result
+}
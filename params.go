@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+// paramsEndpoint evaluates the params files of apps. Its code is the JSON of
+// an api.ParamRequest naming the environment whose params to evaluate, so
+// they go through the same cache, limits and timeout as evaluating jsonnet.
+var paramsEndpoint = jsonnetEndpoint{
+	name: "params",
+	op:   evalParams,
+}
+
+// componentParams maps components to the values of their parameters.
+type componentParams map[string]map[string]interface{}
+
+// evalParams evaluates the params.libsonnet of the environment of the app in
+// code, a JSON api.ParamRequest, or the global one if it names none. The
+// output is the JSON of its components' parameters.
+func evalParams(ctx context.Context, code string) (string, []LogLine, error) {
+	var req api.ParamRequest
+	if err := json.Unmarshal([]byte(code), &req); err != nil {
+		return "", nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "ksonnet-app-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := writeApp(dir, req.Components, req.Environments); err != nil {
+		return "", nil, err
+	}
+
+	file := filepath.Join(dir, "components", "params.libsonnet")
+	if req.Environment != "" {
+		file = filepath.Join(dir, "environments", req.Environment, "params.libsonnet")
+	}
+	stdout, stderr, err := execJsonnet(ctx, file)
+	errOutput, logs := parseStderr(stderr, err != nil)
+	if err != nil {
+		// Don't leak where the app was written
+		return strings.Replace(errOutput, dir+string(filepath.Separator), "", -1), logs, err
+	}
+
+	var params struct {
+		Components componentParams `json:"components"`
+	}
+	if err := json.Unmarshal(stdout, &params); err != nil {
+		return "", logs, badInputError("params.libsonnet must evaluate to an object with the components' parameters")
+	}
+	output, err := json.Marshal(params.Components)
+	return string(output), logs, err
+}
+
+// paramsFile returns the directory of the app in req that holds the
+// params.libsonnet of its environment, or the global one.
+func paramsFile(req api.ParamRequest) (map[string]interface{}, error) {
+	dir := req.Components
+	if req.Environment != "" {
+		env, err := environmentDir(req.Environments, req.Environment)
+		if err != nil {
+			return nil, fmt.Errorf("No environment %q in the app", req.Environment)
+		}
+		dir = env
+	}
+	if _, ok := dir["params.libsonnet"].(string); !ok {
+		return nil, errNotFound
+	}
+	return dir, nil
+}
+
+// setParam sets a parameter of a component in the contents of a
+// params.libsonnet, which is an environment's if env is set.
+func setParam(code string, env bool, req api.ParamRequest) (string, error) {
+	if req.Value == nil {
+		return "", badInputError("Missing the value to set the parameter to")
+	}
+	if _, ok := req.Components[req.Component+".jsonnet"]; !ok {
+		return "", badInputError(fmt.Sprintf("No component %q in the app", req.Component))
+	}
+	value, err := json.Marshal(req.Value)
+	if err != nil {
+		return "", err
+	}
+
+	// Environments extend the global params rather than replace them
+	sep := ":"
+	if env {
+		sep = " +:"
+	}
+	top, err := lastTopLevelObject(code)
+	if err != nil {
+		return "", err
+	}
+	code, components, err := childObject(code, top, "components", sep)
+	if err != nil {
+		return "", err
+	}
+	code, component, err := childObject(code, components, req.Component, sep)
+	if err != nil {
+		return "", err
+	}
+	// JSON is valid jsonnet
+	return setField(code, component, req.Param, string(value)), nil
+}
+
+// unsetParam removes a parameter of a component from the contents of a
+// params.libsonnet, so it takes its global value again.
+func unsetParam(code string, env bool, req api.ParamRequest) (string, error) {
+	obj, err := lastTopLevelObject(code)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range []string{"components", req.Component} {
+		f := obj.field(key)
+		if f == nil || f.valueStart < 0 || code[f.valueStart] != '{' {
+			return "", errNotFound
+		}
+		if obj, err = parseObject(code, f.valueStart); err != nil {
+			return "", err
+		}
+	}
+	f := obj.field(req.Param)
+	if f == nil {
+		return "", errNotFound
+	}
+	return removeField(code, f), nil
+}
+
+// paramHandler serves the `ks param` operation edit, which changes a
+// params.libsonnet of the app in the request and responds with the changed
+// app.
+func paramHandler(edit func(code string, env bool, req api.ParamRequest) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req api.ParamRequest
+		if !readAppRequest(w, r, &req) {
+			return
+		}
+		if req.Component == "" || req.Param == "" {
+			writeError(w, http.StatusBadRequest, errors.New("Missing the component or the parameter"))
+			return
+		}
+		dir, err := paramsFile(req)
+		if err == errNotFound {
+			writeError(w, http.StatusBadRequest, errors.New("The app has no params.libsonnet to edit"))
+			return
+		} else if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		code, err := edit(dir["params.libsonnet"].(string), req.Environment != "", req)
+		if err == errNotFound {
+			writeError(w, http.StatusNotFound,
+				fmt.Errorf("Parameter %q of component %q isn't set in params.libsonnet", req.Param, req.Component))
+			return
+		} else if _, ok := err.(badInputError); ok {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Could not edit params.libsonnet: %v", err))
+			return
+		}
+		dir["params.libsonnet"] = code
+
+		writeJSON(w, api.InitResponse{
+			AppName:      req.AppName,
+			AppYAML:      req.AppYAML,
+			Components:   req.Components,
+			Environments: req.Environments,
+		})
+	}
+}
+
+// effectiveParams evaluates the parameters of the components of the app in
+// req for the given environment, or the global ones. If that fails, the
+// result to respond with is returned instead.
+func effectiveParams(r *http.Request, req api.ParamRequest, env string) (componentParams, *CachedResult) {
+	if _, err := paramsFile(api.ParamRequest{Components: req.Components, Environments: req.Environments, Environment: env}); err != nil {
+		status := http.StatusNotFound
+		if err == errNotFound {
+			status, err = http.StatusBadRequest, errors.New("The app has no params.libsonnet")
+		}
+		result := errorResult(status, err)
+		return nil, &result
+	}
+
+	// Imports resolve against the library directory, so .metadata is left
+	// out of the cache key
+	for _, dir := range req.Environments {
+		if dir, ok := dir.(map[string]interface{}); ok {
+			delete(dir, ".metadata")
+		}
+	}
+	code, _ := json.Marshal(api.ParamRequest{
+		Components:   req.Components,
+		Environments: req.Environments,
+		Environment:  env,
+	})
+	result := paramsEndpoint.evaluate(r.Context(), clientID(r), JsonnetRequest{Code: string(code)})
+	if result.Response.Error != nil {
+		return nil, &result
+	}
+
+	var params componentParams
+	if err := json.Unmarshal([]byte(*result.Response.Output), &params); err != nil {
+		result := errorResult(http.StatusInternalServerError, err)
+		return nil, &result
+	}
+	return params, nil
+}
+
+// sortedParams lists params sorted by component and parameter.
+func sortedParams(params componentParams) []api.Param {
+	list := []api.Param{}
+	for component, values := range params {
+		for param, value := range values {
+			list = append(list, api.Param{Component: component, Param: param, Value: value})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Component != list[j].Component {
+			return list[i].Component < list[j].Component
+		}
+		return list[i].Param < list[j].Param
+	})
+	return list
+}
+
+// paramListHandler lists the effective parameters of the components of an
+// app in an environment, or of one component, the way `ks param list` does.
+func paramListHandler(w http.ResponseWriter, r *http.Request) {
+	var req api.ParamRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	params, failed := effectiveParams(r, req, req.Environment)
+	if failed != nil {
+		paramsEndpoint.write(w, r, *failed)
+		return
+	}
+	if req.Component != "" {
+		params = componentParams{req.Component: params[req.Component]}
+	}
+	writeJSON(w, api.ParamListResponse{Params: sortedParams(params)})
+}
+
+// paramDiffHandler lists the parameters whose values differ between two
+// environments of an app, the way `ks param diff` does.
+func paramDiffHandler(w http.ResponseWriter, r *http.Request) {
+	var req api.ParamRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	if req.Environment == "" || req.OtherEnvironment == "" {
+		writeError(w, http.StatusBadRequest, errors.New("Missing the environments to compare"))
+		return
+	}
+	params, failed := effectiveParams(r, req, req.Environment)
+	if failed != nil {
+		paramsEndpoint.write(w, r, *failed)
+		return
+	}
+	other, failed := effectiveParams(r, req, req.OtherEnvironment)
+	if failed != nil {
+		paramsEndpoint.write(w, r, *failed)
+		return
+	}
+
+	// Parameters set in either environment
+	all := componentParams{}
+	for _, p := range [][]api.Param{sortedParams(params), sortedParams(other)} {
+		for _, param := range p {
+			if all[param.Component] == nil {
+				all[param.Component] = map[string]interface{}{}
+			}
+			all[param.Component][param.Param] = nil
+		}
+	}
+	resp := api.ParamDiffResponse{Diffs: []api.ParamDiff{}}
+	for _, p := range sortedParams(all) {
+		value, ok := params[p.Component][p.Param]
+		otherValue, otherOk := other[p.Component][p.Param]
+		if ok == otherOk && reflect.DeepEqual(value, otherValue) {
+			continue
+		}
+		resp.Diffs = append(resp.Diffs, api.ParamDiff{
+			Component:  p.Component,
+			Param:      p.Param,
+			Value:      value,
+			OtherValue: otherValue,
+		})
+	}
+	writeJSON(w, resp)
+}
+
+// renameParams renames the parameters of a component in the contents of a
+// params.libsonnet, if it has any.
+func renameParams(code, from, to string) (string, error) {
+	top, err := lastTopLevelObject(code)
+	if err != nil {
+		return "", err
+	}
+	f := top.field("components")
+	if f == nil || f.valueStart < 0 || code[f.valueStart] != '{' {
+		return code, nil
+	}
+	components, err := parseObject(code, f.valueStart)
+	if err != nil {
+		return "", err
+	}
+	params := components.field(from)
+	if params == nil {
+		return code, nil
+	}
+	if components.field(to) != nil {
+		return "", errComponentExists
+	}
+	return renameField(code, params, to)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+// prototypeAPIVersion is the only version of the prototype header syntax
+// ksonnet defines.
+const prototypeAPIVersion = "0.0.1"
+
+// paramTypes are the types a prototype parameter can be declared with.
+var paramTypes = map[string]bool{
+	"string":           true,
+	"number":           true,
+	"number-or-string": true,
+	"bool":             true,
+	"array":            true,
+	"object":           true,
+}
+
+// paramImportRegexp matches the placeholders that generate fills with the
+// value of a parameter, e.g. import 'param://name'.
+var paramImportRegexp = regexp.MustCompile(`import\s+(?:'param://([^'/]+)'|"param://([^"/]+)")`)
+
+// PrototypeParam is a parameter of a Prototype. Default is the jsonnet text
+// of the value used for an optional parameter that's left out.
+type PrototypeParam struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Description string  `json:"description"`
+	Optional    bool    `json:"optional"`
+	Default     *string `json:"default,omitempty"`
+}
+
+// Prototype is a template for a component, read from a file in the prototype
+// directory with ksonnet's header syntax:
+//
+//	// @apiVersion 0.0.1
+//	// @name io.ksonnet.pkg.redis
+//	// @description Redis with a service in front of it
+//	// @param name string Name of the deployment and service
+//	// @optionalParam replicas number 1 Number of replicas
+type Prototype struct {
+	APIVersion       string           `json:"apiVersion"`
+	Name             string           `json:"name"`
+	Description      string           `json:"description"`
+	ShortDescription string           `json:"shortDescription,omitempty"`
+	Params           []PrototypeParam `json:"params"`
+	// template is the file without its header
+	template string
+}
+
+// shortName is the last part of the prototype's name, which generate also
+// accepts, as ks does.
+func (p *Prototype) shortName() string {
+	return p.Name[strings.LastIndex(p.Name, ".")+1:]
+}
+
+// parsePrototype reads a prototype from the contents of its file. The header
+// is the leading block of comments; a comment line without a tag continues
+// the description before it.
+func parsePrototype(contents string) (*Prototype, error) {
+	p := &Prototype{Params: []PrototypeParam{}}
+	var template []string
+	inHeader, lastTag := true, ""
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	scanner.Buffer(nil, len(contents)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if !inHeader || !strings.HasPrefix(trimmed, "//") {
+			inHeader = false
+			template = append(template, line)
+			continue
+		}
+
+		comment := strings.TrimSpace(strings.TrimPrefix(trimmed, "//"))
+		if !strings.HasPrefix(comment, "@") {
+			if lastTag == "@description" && comment != "" {
+				p.Description += " " + comment
+			}
+			continue
+		}
+		fields := strings.Fields(comment)
+		lastTag = fields[0]
+		rest := strings.TrimSpace(strings.TrimPrefix(comment, fields[0]))
+
+		switch lastTag {
+		case "@apiVersion":
+			p.APIVersion = rest
+		case "@name":
+			p.Name = rest
+		case "@description":
+			p.Description = rest
+		case "@shortDescription":
+			p.ShortDescription = rest
+		case "@param":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("Expected '@param <name> <type> <description>', got %q", comment)
+			}
+			p.Params = append(p.Params, PrototypeParam{
+				Name:        fields[1],
+				Type:        fields[2],
+				Description: strings.Join(fields[3:], " "),
+			})
+		case "@optionalParam":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("Expected '@optionalParam <name> <type> <default> <description>', got %q", comment)
+			}
+			def := fields[3]
+			p.Params = append(p.Params, PrototypeParam{
+				Name:        fields[1],
+				Type:        fields[2],
+				Description: strings.Join(fields[4:], " "),
+				Optional:    true,
+				Default:     &def,
+			})
+		default:
+			return nil, fmt.Errorf("Unknown prototype tag %q", lastTag)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.APIVersion != prototypeAPIVersion {
+		return nil, fmt.Errorf("Unsupported prototype @apiVersion %q - Expected %q", p.APIVersion, prototypeAPIVersion)
+	}
+	if p.Name == "" {
+		return nil, fmt.Errorf("Prototype has no @name")
+	}
+	for _, param := range p.Params {
+		if !paramTypes[param.Type] {
+			return nil, fmt.Errorf("Parameter %q has unknown type %q", param.Name, param.Type)
+		}
+	}
+	p.template = strings.TrimLeft(strings.Join(template, "\n"), "\n") + "\n"
+	return p, nil
+}
+
+// loadPrototypes reads every .jsonnet file under the prototype directory,
+// sorted by name. Files that aren't valid prototypes are logged and skipped,
+// so one bad file doesn't hide the rest.
+func loadPrototypes() ([]*Prototype, error) {
+	seen := map[string]string{}
+	prototypes := []*Prototype{}
+	err := filepath.Walk(config.PrototypeDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == config.PrototypeDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".jsonnet") {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p, err := parsePrototype(string(contents))
+		if err != nil {
+			log.Printf("Skipping prototype %s: %v", path, err)
+			return nil
+		}
+		if other, ok := seen[p.Name]; ok {
+			log.Printf("Skipping prototype %s: %s already defines %s", path, other, p.Name)
+			return nil
+		}
+		seen[p.Name] = path
+		prototypes = append(prototypes, p)
+		return nil
+	})
+	sort.Slice(prototypes, func(i, j int) bool { return prototypes[i].Name < prototypes[j].Name })
+	return prototypes, err
+}
+
+// findPrototype returns the prototype with the given full or short name.
+func findPrototype(name string) (*Prototype, error) {
+	prototypes, err := loadPrototypes()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prototypes {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	var found *Prototype
+	for _, p := range prototypes {
+		if p.shortName() == name {
+			if found != nil {
+				return nil, badInputError(fmt.Sprintf("Prototype name %q is ambiguous - Use the full name", name))
+			}
+			found = p
+		}
+	}
+	if found == nil {
+		return nil, errNotFound
+	}
+	return found, nil
+}
+
+// matches reports whether every word of query occurs in the prototype's name
+// or descriptions, ignoring case.
+func (p *Prototype) matches(query string) bool {
+	text := strings.ToLower(p.Name + "\n" + p.Description + "\n" + p.ShortDescription)
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// paramsSchema returns a JSON Schema for the parameters object of a generate
+// request for the prototype.
+func (p *Prototype) paramsSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, param := range p.Params {
+		schema := map[string]interface{}{"description": param.Description}
+		switch param.Type {
+		case "number-or-string":
+			schema["type"] = []string{"number", "string"}
+		case "bool":
+			schema["type"] = "boolean"
+		default:
+			schema["type"] = param.Type
+		}
+		if param.Optional {
+			// Defaults are jsonnet, which is only shown if it's also JSON
+			var def interface{}
+			if param.Type == "string" {
+				schema["default"] = *param.Default
+			} else if json.Unmarshal([]byte(*param.Default), &def) == nil {
+				schema["default"] = def
+			}
+		} else {
+			required = append(required, param.Name)
+		}
+		properties[param.Name] = schema
+	}
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                p.Name,
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// PrototypesResponse lists the prototypes generate can use.
+type PrototypesResponse struct {
+	Prototypes []*Prototype `json:"prototypes"`
+}
+
+// PrototypeResponse describes a prototype. ParamsSchema is a JSON Schema for
+// the parameters of a generate request for it.
+type PrototypeResponse struct {
+	Prototype    *Prototype             `json:"prototype"`
+	ParamsSchema map[string]interface{} `json:"paramsSchema"`
+}
+
+// prototypesHandler lists the prototypes, or those matching the q query
+// parameter.
+func prototypesHandler(w http.ResponseWriter, r *http.Request) {
+	prototypes, err := loadPrototypes()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Could not load prototypes: %v", err))
+		return
+	}
+
+	resp := PrototypesResponse{Prototypes: []*Prototype{}}
+	query := r.URL.Query().Get("q")
+	for _, p := range prototypes {
+		if p.matches(query) {
+			resp.Prototypes = append(resp.Prototypes, p)
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// prototypeHandler describes the prototype with the full or short name in
+// the path.
+func prototypeHandler(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "/prototypes/")
+	p, err := findPrototype(name)
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No prototype named %q", name))
+		return
+	} else if _, ok := err.(badInputError); ok {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Could not load prototypes: %v", err))
+		return
+	}
+	writeJSON(w, PrototypeResponse{Prototype: p, ParamsSchema: p.paramsSchema()})
+}
+
+// writeJSON responds with resp serialized as JSON.
+func writeJSON(w http.ResponseWriter, resp interface{}) {
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// checkParamType returns an error if value, decoded from JSON, isn't of the
+// given parameter type.
+func checkParamType(name, typ string, value interface{}) error {
+	ok := false
+	switch value.(type) {
+	case string:
+		ok = typ == "string" || typ == "number-or-string"
+	case float64:
+		ok = typ == "number" || typ == "number-or-string"
+	case bool:
+		ok = typ == "bool"
+	case []interface{}:
+		ok = typ == "array"
+	case map[string]interface{}:
+		ok = typ == "object"
+	}
+	if !ok {
+		return badInputError(fmt.Sprintf("Parameter %q must be of type %s", name, typ))
+	}
+	return nil
+}
+
+// paramValues returns the jsonnet text of the value of each of the
+// prototype's parameters, checking that params holds every required one and
+// only values of the right type for known ones.
+func (p *Prototype) paramValues(params map[string]interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	for _, param := range p.Params {
+		value, ok := params[param.Name]
+		if !ok {
+			if !param.Optional {
+				return nil, badInputError(fmt.Sprintf("Missing required parameter %q", param.Name))
+			}
+			// Defaults of string parameters are written bare in the header
+			if param.Type == "string" {
+				quoted, _ := json.Marshal(*param.Default)
+				values[param.Name] = string(quoted)
+			} else {
+				values[param.Name] = *param.Default
+			}
+			continue
+		}
+		if err := checkParamType(param.Name, param.Type, value); err != nil {
+			return nil, err
+		}
+		// JSON is valid jsonnet
+		text, _ := json.Marshal(value)
+		values[param.Name] = string(text)
+	}
+	for name := range params {
+		if _, ok := values[name]; !ok {
+			return nil, badInputError(fmt.Sprintf("Unknown parameter %q for prototype %s", name, p.Name))
+		}
+	}
+	return values, nil
+}
+
+// generate fills the prototype's template with params.
+func (p *Prototype) generate(params map[string]interface{}) (string, error) {
+	values, err := p.paramValues(params)
+	if err != nil {
+		return "", err
+	}
+	var missing error
+	component := paramImportRegexp.ReplaceAllStringFunc(p.template, func(placeholder string) string {
+		m := paramImportRegexp.FindStringSubmatch(placeholder)
+		name := m[1] + m[2]
+		value, ok := values[name]
+		if !ok && missing == nil {
+			missing = fmt.Errorf("Prototype %s uses undeclared parameter %q", p.Name, name)
+		}
+		return value
+	})
+	return component, missing
+}
+
+// ksGenerate fills a prototype with parameters, returning the new component,
+// the way `ks generate` does.
+func ksGenerate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxContentLength)
+	defer r.Body.Close()
+
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	var req api.GenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, errors.New("Missing the name of the prototype to generate from"))
+		return
+	}
+	p, err := findPrototype(req.Name)
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No prototype named %q", req.Name))
+		return
+	} else if _, ok := err.(badInputError); ok {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("Could not load prototypes: %v", err))
+		return
+	}
+
+	// Like ks, name the component after its name parameter by default
+	component := req.ComponentName
+	if name, ok := req.Parameters["name"].(string); ok && component == "" {
+		component = name
+	}
+	if component == "" {
+		component = p.shortName()
+	}
+	if err := validateName("component", component); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	contents, err := p.generate(req.Parameters)
+	if _, ok := err.(badInputError); ok {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, api.GenerateResponse{
+		Components: map[string]interface{}{component + ".jsonnet": contents},
+	})
+}
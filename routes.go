@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// apiPrefix is the root of the versioned API. Routes under it keep their
+// paths and request/response shapes across releases.
+const apiPrefix = "/api/v1"
+
+var (
+	originRegexp        = regexp.MustCompile(`^https?://.*\.heptio\.com|localhost:\d+$`)
+	errNotFound         = errors.New("Not found")
+	errMethodNotAllowed = errors.New("Method not allowed")
+)
+
+// methods dispatches a request to the handler registered for its HTTP method
+// and answers any other method with 405 Method Not Allowed.
+type methods map[string]http.HandlerFunc
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := m[r.Method]; ok {
+		h(w, r)
+		return
+	}
+	w.Header().Set("Allow", m.allow())
+	writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+}
+
+// allow lists the methods accepted by m, for the Allow and CORS headers.
+func (m methods) allow() string {
+	allowed := []string{http.MethodOptions}
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// writeError responds with an error in the same `JsonnetResponse` shape that
+// the evaluation endpoints use.
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	w.Write([]byte(errorResponse(statusErrorCode(code), "", err)))
+}
+
+// withCORS sets CORS headers for allowed origins and answers preflight
+// OPTIONS requests without calling through to m.
+func withCORS(m methods) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); config.SkipCorsCheck || originRegexp.Match([]byte(origin)) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", m.allow())
+			w.Header().Set("Access-Control-Allow-Headers",
+				"Accept, Authorization, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token")
+		}
+
+		// And if this is an OPTIONS request, stop here (don't process the body)
+		if r.Method == http.MethodOptions {
+			return
+		}
+		m.ServeHTTP(w, r)
+	})
+}
+
+// instrument wraps h in the request metrics shared by every API route.
+func instrument(h http.Handler) http.Handler {
+	return promhttp.InstrumentHandlerInFlight(p8sRunningRequests,
+		promhttp.InstrumentHandlerCounter(p8sRequests,
+			promhttp.InstrumentHandlerDuration(p8sRequestDuration, h),
+		),
+	)
+}
+
+// deprecated marks responses from h as coming from an unversioned route,
+// pointing clients at its replacement under apiPrefix.
+func deprecated(successor string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+successor+`>; rel="successor-version"`)
+		h(w, r)
+	}
+}
+
+// apiRoutes maps each path under apiPrefix to the handlers for its methods.
+// A {name} segment stands for any value, which the handler reads with
+// pathParam.
+func apiRoutes() map[string]methods {
+	return map[string]methods{
+		"/eval":      {http.MethodPost: handler},
+		"/batch":     {http.MethodPost: batchHandler},
+		"/format":    {http.MethodPost: formatHandler},
+		"/check":     {http.MethodPost: checkHandler},
+		"/jobs":      {http.MethodPost: submitJobHandler},
+		"/jobs/{id}": {http.MethodGet: jobHandler, http.MethodDelete: cancelJobHandler},
+		"/live":      {http.MethodGet: liveHandler},
+		"/libraries": {http.MethodGet: librariesHandler, http.MethodPost: generateLibraryHandler},
+		"/init":      {http.MethodPost: ksInit},
+		"/show":      {http.MethodPost: ksShow},
+		"/generate":  {http.MethodPost: ksGenerate},
+		"/env/list":  {http.MethodPost: envListHandler},
+		"/env/add":   {http.MethodPost: envHandler(addEnvironment)},
+		"/env/set":   {http.MethodPost: envHandler(updateEnvironment)},
+		"/env/rm":    {http.MethodPost: envHandler(removeEnvironment)},
+
+		"/param/list":  {http.MethodPost: paramListHandler},
+		"/param/set":   {http.MethodPost: paramHandler(setParam)},
+		"/param/unset": {http.MethodPost: paramHandler(unsetParam)},
+		"/param/diff":  {http.MethodPost: paramDiffHandler},
+		"/diff":        {http.MethodPost: diffHandler},
+		"/export":      {http.MethodPost: exportHandler},
+		"/convert":     {http.MethodPost: convertHandler},
+
+		"/apply/preview": {http.MethodPost: applyPreviewHandler},
+
+		"/workspaces":      {http.MethodPost: createWorkspaceHandler},
+		"/workspaces/{id}": {http.MethodGet: workspaceHandler, http.MethodDelete: deleteWorkspaceHandler},
+		"/workspaces/{id}/files": {
+			http.MethodGet:    fileHandler,
+			http.MethodPut:    writeFileHandler,
+			http.MethodDelete: deleteFileHandler,
+		},
+		"/workspaces/{id}/rename":    {http.MethodPost: renameHandler},
+		"/workspaces/{id}/show":      {http.MethodPost: showWorkspaceHandler},
+		"/workspaces/{id}/revisions": {http.MethodGet: revisionsHandler},
+		"/workspaces/{id}/diff":      {http.MethodGet: revisionDiffHandler},
+		"/workspaces/{id}/rollback":  {http.MethodPost: rollbackHandler},
+		"/workspaces/{id}/archive":   {http.MethodGet: archiveHandler},
+		"/workspaces/{id}/env/list":  {http.MethodGet: workspaceEnvListHandler},
+		"/workspaces/{id}/env/add":   {http.MethodPost: workspaceEnvHandler("Add", addEnvironment)},
+		"/workspaces/{id}/env/set":   {http.MethodPost: workspaceEnvHandler("Update", updateEnvironment)},
+		"/workspaces/{id}/env/rm":    {http.MethodPost: workspaceEnvHandler("Remove", removeEnvironment)},
+		"/workspaces/import":         {http.MethodPost: importHandler},
+
+		"/libraries/{name}":  {http.MethodDelete: deleteLibraryHandler},
+		"/prototypes":        {http.MethodGet: prototypesHandler},
+		"/prototypes/{name}": {http.MethodGet: prototypeHandler},
+
+		"/openapi.json": {http.MethodGet: openAPIHandler},
+	}
+}
+
+// pathParam returns the value of the {name} segment of the route r was sent
+// to that follows prefix.
+func pathParam(r *http.Request, prefix string) string {
+	value := strings.TrimPrefix(r.URL.Path, apiPrefix+prefix)
+	if i := strings.Index(value, "/"); i >= 0 {
+		value = value[:i]
+	}
+	return value
+}
+
+// templatedRoutes serves the routes with {name} segments that share a
+// prefix, which the mux can only match as a whole subtree.
+type templatedRoutes map[string]http.Handler
+
+func (t templatedRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for path, h := range t {
+		if matchPath(apiPrefix+path, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, errNotFound)
+}
+
+// matchPath reports whether path matches the route pattern, in which each
+// {name} segment stands for any non-empty value.
+func matchPath(pattern, path string) bool {
+	want, got := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if strings.HasPrefix(want[i], "{") && got[i] != "" {
+			continue
+		}
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// newAPIMux builds the mux for the public API server. Besides the versioned
+// routes it keeps the unversioned routes older clients use, marked as
+// deprecated, and answers everything else with a 404.
+func newAPIMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	templated := map[string]templatedRoutes{}
+	for path, m := range apiRoutes() {
+		if i := strings.Index(path, "{"); i >= 0 {
+			if templated[path[:i]] == nil {
+				templated[path[:i]] = templatedRoutes{}
+			}
+			templated[path[:i]][path] = withCORS(m)
+			continue
+		}
+		mux.Handle(apiPrefix+path, instrument(withCORS(m)))
+	}
+	for prefix, routes := range templated {
+		mux.Handle(apiPrefix+prefix, instrument(routes))
+	}
+
+	legacy := map[string]methods{
+		"/show": {
+			http.MethodGet:  deprecated("/show", ksShow),
+			http.MethodPost: deprecated("/show", ksShow),
+		},
+		"/generate": {
+			http.MethodGet:  deprecated("/generate", ksGenerate),
+			http.MethodPost: deprecated("/generate", ksGenerate),
+		},
+	}
+	for path, m := range legacy {
+		mux.Handle(path, instrument(withCORS(m)))
+	}
+
+	root := withCORS(methods{http.MethodPost: deprecated("/eval", legacyHandler)})
+	mux.Handle("/", instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeError(w, http.StatusNotFound, errNotFound)
+			return
+		}
+		root.ServeHTTP(w, r)
+	})))
+
+	return mux
+}
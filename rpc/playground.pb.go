@@ -0,0 +1,376 @@
+// Code generated by protoc-gen-go.
+// source: playground.proto
+// DO NOT EDIT!
+
+/*
+Package rpc is a generated protocol buffer package.
+
+It is generated from these files:
+
+	playground.proto
+
+It has these top-level messages:
+
+	JsonnetRequest
+	JsonnetResponse
+	LogLine
+	Diagnostic
+	OrderedObject
+	BatchRequest
+	BatchItem
+*/
+package rpc
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+// validate checks the objects the code evaluates to against the OpenAPI
+// spec of k8s_version, order sorts them into the order they can be applied
+// in, and library picks the library imports resolve against, as in the HTTP
+// API.
+type JsonnetRequest struct {
+	Code       string `protobuf:"bytes,1,opt,name=code" json:"code,omitempty"`
+	Validate   bool   `protobuf:"varint,2,opt,name=validate" json:"validate,omitempty"`
+	K8SVersion string `protobuf:"bytes,3,opt,name=k8s_version,json=k8sVersion" json:"k8s_version,omitempty"`
+	Order      bool   `protobuf:"varint,4,opt,name=order" json:"order,omitempty"`
+	Library    string `protobuf:"bytes,5,opt,name=library" json:"library,omitempty"`
+}
+
+func (m *JsonnetRequest) Reset()                    { *m = JsonnetRequest{} }
+func (m *JsonnetRequest) String() string            { return proto.CompactTextString(m) }
+func (*JsonnetRequest) ProtoMessage()               {}
+func (*JsonnetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *JsonnetRequest) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *JsonnetRequest) GetValidate() bool {
+	if m != nil {
+		return m.Validate
+	}
+	return false
+}
+
+func (m *JsonnetRequest) GetK8SVersion() string {
+	if m != nil {
+		return m.K8SVersion
+	}
+	return ""
+}
+
+func (m *JsonnetRequest) GetOrder() bool {
+	if m != nil {
+		return m.Order
+	}
+	return false
+}
+
+func (m *JsonnetRequest) GetLibrary() string {
+	if m != nil {
+		return m.Library
+	}
+	return ""
+}
+
+// Either output or error is set. Errors also carry one of the codes of the
+// HTTP API, e.g. PARSE_ERROR, and whether the request is worth retrying.
+// logs holds std.trace output and warnings either way. diagnostics and order
+// are set when the request asked to validate or order the output.
+type JsonnetResponse struct {
+	Output      string           `protobuf:"bytes,1,opt,name=output" json:"output,omitempty"`
+	Error       string           `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+	Code        string           `protobuf:"bytes,3,opt,name=code" json:"code,omitempty"`
+	Retryable   bool             `protobuf:"varint,4,opt,name=retryable" json:"retryable,omitempty"`
+	Logs        []*LogLine       `protobuf:"bytes,5,rep,name=logs" json:"logs,omitempty"`
+	Diagnostics []*Diagnostic    `protobuf:"bytes,6,rep,name=diagnostics" json:"diagnostics,omitempty"`
+	Order       []*OrderedObject `protobuf:"bytes,7,rep,name=order" json:"order,omitempty"`
+}
+
+func (m *JsonnetResponse) Reset()                    { *m = JsonnetResponse{} }
+func (m *JsonnetResponse) String() string            { return proto.CompactTextString(m) }
+func (*JsonnetResponse) ProtoMessage()               {}
+func (*JsonnetResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *JsonnetResponse) GetOutput() string {
+	if m != nil {
+		return m.Output
+	}
+	return ""
+}
+
+func (m *JsonnetResponse) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *JsonnetResponse) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *JsonnetResponse) GetRetryable() bool {
+	if m != nil {
+		return m.Retryable
+	}
+	return false
+}
+
+func (m *JsonnetResponse) GetLogs() []*LogLine {
+	if m != nil {
+		return m.Logs
+	}
+	return nil
+}
+
+func (m *JsonnetResponse) GetDiagnostics() []*Diagnostic {
+	if m != nil {
+		return m.Diagnostics
+	}
+	return nil
+}
+
+func (m *JsonnetResponse) GetOrder() []*OrderedObject {
+	if m != nil {
+		return m.Order
+	}
+	return nil
+}
+
+// A message jsonnet printed besides the error. kind is "trace" or "warning",
+// and file, line and column locate the code that printed it, when known.
+type LogLine struct {
+	Kind    string `protobuf:"bytes,1,opt,name=kind" json:"kind,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	File    string `protobuf:"bytes,3,opt,name=file" json:"file,omitempty"`
+	Line    int32  `protobuf:"varint,4,opt,name=line" json:"line,omitempty"`
+	Column  int32  `protobuf:"varint,5,opt,name=column" json:"column,omitempty"`
+}
+
+func (m *LogLine) Reset()                    { *m = LogLine{} }
+func (m *LogLine) String() string            { return proto.CompactTextString(m) }
+func (*LogLine) ProtoMessage()               {}
+func (*LogLine) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *LogLine) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *LogLine) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *LogLine) GetFile() string {
+	if m != nil {
+		return m.File
+	}
+	return ""
+}
+
+func (m *LogLine) GetLine() int32 {
+	if m != nil {
+		return m.Line
+	}
+	return 0
+}
+
+func (m *LogLine) GetColumn() int32 {
+	if m != nil {
+		return m.Column
+	}
+	return 0
+}
+
+// A problem validation found with the output, at the jq-style path of the
+// value it's about.
+type Diagnostic struct {
+	Path    string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *Diagnostic) Reset()                    { *m = Diagnostic{} }
+func (m *Diagnostic) String() string            { return proto.CompactTextString(m) }
+func (*Diagnostic) ProtoMessage()               {}
+func (*Diagnostic) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *Diagnostic) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *Diagnostic) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+// An object of ordered output, with the reason it's where it is.
+type OrderedObject struct {
+	ApiVersion string `protobuf:"bytes,1,opt,name=api_version,json=apiVersion" json:"api_version,omitempty"`
+	Kind       string `protobuf:"bytes,2,opt,name=kind" json:"kind,omitempty"`
+	Namespace  string `protobuf:"bytes,3,opt,name=namespace" json:"namespace,omitempty"`
+	Name       string `protobuf:"bytes,4,opt,name=name" json:"name,omitempty"`
+	Reason     string `protobuf:"bytes,5,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *OrderedObject) Reset()                    { *m = OrderedObject{} }
+func (m *OrderedObject) String() string            { return proto.CompactTextString(m) }
+func (*OrderedObject) ProtoMessage()               {}
+func (*OrderedObject) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *OrderedObject) GetApiVersion() string {
+	if m != nil {
+		return m.ApiVersion
+	}
+	return ""
+}
+
+func (m *OrderedObject) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *OrderedObject) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *OrderedObject) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *OrderedObject) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+type BatchRequest struct {
+	Requests []*JsonnetRequest `protobuf:"bytes,1,rep,name=requests" json:"requests,omitempty"`
+}
+
+func (m *BatchRequest) Reset()                    { *m = BatchRequest{} }
+func (m *BatchRequest) String() string            { return proto.CompactTextString(m) }
+func (*BatchRequest) ProtoMessage()               {}
+func (*BatchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *BatchRequest) GetRequests() []*JsonnetRequest {
+	if m != nil {
+		return m.Requests
+	}
+	return nil
+}
+
+// The result for requests[index] of a BatchRequest.
+type BatchItem struct {
+	Index    int32            `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Response *JsonnetResponse `protobuf:"bytes,2,opt,name=response" json:"response,omitempty"`
+}
+
+func (m *BatchItem) Reset()                    { *m = BatchItem{} }
+func (m *BatchItem) String() string            { return proto.CompactTextString(m) }
+func (*BatchItem) ProtoMessage()               {}
+func (*BatchItem) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *BatchItem) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *BatchItem) GetResponse() *JsonnetResponse {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*JsonnetRequest)(nil), "ksonnet.playground.v1.JsonnetRequest")
+	proto.RegisterType((*JsonnetResponse)(nil), "ksonnet.playground.v1.JsonnetResponse")
+	proto.RegisterType((*LogLine)(nil), "ksonnet.playground.v1.LogLine")
+	proto.RegisterType((*Diagnostic)(nil), "ksonnet.playground.v1.Diagnostic")
+	proto.RegisterType((*OrderedObject)(nil), "ksonnet.playground.v1.OrderedObject")
+	proto.RegisterType((*BatchRequest)(nil), "ksonnet.playground.v1.BatchRequest")
+	proto.RegisterType((*BatchItem)(nil), "ksonnet.playground.v1.BatchItem")
+}
+
+func init() { proto.RegisterFile("playground.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 585 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0xdd, 0x6e, 0xd3, 0x4c,
+	0x10, 0x95, 0xd3, 0x3a, 0x8d, 0x27, 0x5f, 0x3f, 0xd0, 0x0a, 0x90, 0x55, 0x55, 0x10, 0xcc, 0x8f,
+	0x22, 0x24, 0x52, 0x08, 0x37, 0x55, 0xef, 0x48, 0x01, 0x09, 0x54, 0xa9, 0xb0, 0x17, 0xe5, 0xe7,
+	0x06, 0x6d, 0xec, 0xc1, 0x59, 0x62, 0xef, 0x9a, 0xdd, 0x75, 0x20, 0x2f, 0x81, 0xc4, 0x4b, 0xf0,
+	0x80, 0x3c, 0x01, 0xda, 0xf5, 0x4f, 0x52, 0x89, 0x40, 0x2f, 0x7a, 0x37, 0x67, 0x33, 0x67, 0x66,
+	0xce, 0x99, 0x71, 0xe0, 0x6a, 0x91, 0xb1, 0x65, 0xaa, 0x64, 0x29, 0x92, 0x51, 0xa1, 0xa4, 0x91,
+	0xe4, 0xfa, 0x5c, 0x4b, 0x21, 0xd0, 0x8c, 0xd6, 0x7e, 0x59, 0x3c, 0x8e, 0x7e, 0x78, 0xf0, 0xff,
+	0xab, 0xea, 0x17, 0x8a, 0x5f, 0x4a, 0xd4, 0x86, 0x10, 0xd8, 0x8e, 0x65, 0x82, 0xa1, 0x37, 0xf0,
+	0x86, 0x01, 0x75, 0x31, 0xd9, 0x83, 0xde, 0x82, 0x65, 0x3c, 0x61, 0x06, 0xc3, 0xce, 0xc0, 0x1b,
+	0xf6, 0x68, 0x8b, 0xc9, 0x2d, 0xe8, 0xcf, 0x0f, 0xf5, 0xc7, 0x05, 0x2a, 0xcd, 0xa5, 0x08, 0xb7,
+	0x1c, 0x0d, 0xe6, 0x87, 0xfa, 0xac, 0x7a, 0x21, 0xd7, 0xc0, 0x97, 0x2a, 0x41, 0x15, 0x6e, 0x3b,
+	0x66, 0x05, 0x48, 0x08, 0x3b, 0x19, 0x9f, 0x2a, 0xa6, 0x96, 0xa1, 0xef, 0x28, 0x0d, 0x8c, 0x7e,
+	0x76, 0xe0, 0x4a, 0x3b, 0x93, 0x2e, 0xa4, 0xd0, 0x48, 0x6e, 0x40, 0x57, 0x96, 0xa6, 0x28, 0x4d,
+	0x3d, 0x56, 0x8d, 0x6c, 0x6d, 0x54, 0x4a, 0x2a, 0x37, 0x55, 0x40, 0x2b, 0xd0, 0x4a, 0xd8, 0x5a,
+	0x93, 0xb0, 0x0f, 0x81, 0x42, 0xa3, 0x96, 0x6c, 0x9a, 0x61, 0x3d, 0xc9, 0xea, 0x81, 0x8c, 0x61,
+	0x3b, 0x93, 0xa9, 0x0e, 0xfd, 0xc1, 0xd6, 0xb0, 0x3f, 0xbe, 0x39, 0xfa, 0xa3, 0x5b, 0xa3, 0x13,
+	0x99, 0x9e, 0x70, 0x81, 0xd4, 0xe5, 0x92, 0x63, 0xe8, 0x27, 0x9c, 0xa5, 0x42, 0x6a, 0xc3, 0x63,
+	0x1d, 0x76, 0x1d, 0xf5, 0xf6, 0x06, 0xea, 0xb3, 0x36, 0x93, 0xae, 0xb3, 0xc8, 0x51, 0x63, 0xce,
+	0x8e, 0xa3, 0xdf, 0xdd, 0x40, 0x3f, 0xb5, 0x39, 0x98, 0x9c, 0x4e, 0x3f, 0x63, 0x6c, 0x6a, 0x0b,
+	0xa3, 0xaf, 0xb0, 0x53, 0x4f, 0x64, 0x15, 0xcf, 0xb9, 0x48, 0x9a, 0xa5, 0xd9, 0xd8, 0x3a, 0x9c,
+	0xa3, 0xd6, 0x2c, 0xc5, 0xda, 0x9d, 0x06, 0xda, 0xec, 0x4f, 0x3c, 0x6b, 0xfd, 0xb1, 0xb1, 0x7d,
+	0xcb, 0xb8, 0xa8, 0xac, 0xf1, 0xa9, 0x8b, 0xad, 0xeb, 0xb1, 0xcc, 0xca, 0x5c, 0xb8, 0x15, 0xf9,
+	0xb4, 0x46, 0xd1, 0x11, 0xc0, 0x4a, 0x8f, 0x65, 0x16, 0xcc, 0xcc, 0x9a, 0xde, 0x36, 0xde, 0xdc,
+	0x3b, 0xfa, 0xee, 0xc1, 0xee, 0x39, 0x35, 0xf6, 0x80, 0x58, 0xc1, 0xdb, 0x03, 0xaa, 0xca, 0x00,
+	0x2b, 0x78, 0x73, 0x40, 0x8d, 0xb8, 0xce, 0x9a, 0xb8, 0x7d, 0x08, 0x04, 0xcb, 0x51, 0x17, 0x2c,
+	0x6e, 0x74, 0xac, 0x1e, 0x2c, 0xc3, 0x02, 0x27, 0x26, 0xa0, 0x2e, 0xb6, 0x62, 0x14, 0x32, 0x2d,
+	0x45, 0x7d, 0x6f, 0x35, 0x8a, 0xde, 0xc0, 0x7f, 0x13, 0x66, 0xe2, 0x59, 0x73, 0xff, 0x4f, 0xa1,
+	0xa7, 0xaa, 0x50, 0x87, 0x9e, 0x5b, 0xca, 0xbd, 0x0d, 0x4b, 0x39, 0xff, 0xe1, 0xd0, 0x96, 0x16,
+	0x21, 0x04, 0xae, 0xe4, 0x4b, 0x83, 0xb9, 0x3d, 0x51, 0x2e, 0x12, 0xfc, 0xe6, 0x84, 0xf9, 0xb4,
+	0x02, 0x64, 0x62, 0xbb, 0x54, 0xc7, 0xed, 0x74, 0xf5, 0xc7, 0xf7, 0xff, 0xd5, 0xa5, 0xca, 0xa6,
+	0x2d, 0x6f, 0xfc, 0xab, 0x03, 0xf0, 0xba, 0xcd, 0x25, 0xef, 0xa1, 0xf7, 0x7c, 0xc1, 0xb2, 0xd2,
+	0x7e, 0x94, 0x17, 0x1b, 0x79, 0xef, 0x82, 0x3d, 0xc9, 0x5b, 0xe8, 0xbe, 0x90, 0x2a, 0x67, 0xe6,
+	0xb2, 0x0b, 0x9f, 0x81, 0x7f, 0x3c, 0xc3, 0x78, 0x7e, 0xd9, 0x75, 0xdf, 0xc1, 0xae, 0xdb, 0x40,
+	0x6b, 0xc8, 0x9d, 0x0d, 0xc4, 0xf5, 0xd5, 0xef, 0x0d, 0xfe, 0x96, 0x64, 0x97, 0xf9, 0xc8, 0x9b,
+	0x3c, 0xf8, 0x30, 0x4c, 0xb9, 0x99, 0x95, 0xd3, 0x51, 0x2c, 0xf3, 0x83, 0x19, 0x16, 0x86, 0xcb,
+	0x83, 0x9a, 0xf6, 0x70, 0x45, 0x3b, 0x50, 0x45, 0x3c, 0xed, 0xba, 0xff, 0xde, 0x27, 0xbf, 0x07,
+	0x00, 0xe1, 0xd6, 0x1d, 0x35, 0x8f, 0x05, 0x00, 0x00,
+}
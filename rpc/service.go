@@ -0,0 +1,7 @@
+package rpc
+
+//go:generate protoc --go_out=. playground.proto
+
+// Service is the full name of the gRPC service, which prefixes the path of
+// every method.
+const Service = "ksonnet.playground.v1.Playground"
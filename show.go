@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+const (
+	showYAML = "yaml"
+	showJSON = "json"
+)
+
+// showEndpoint renders ksonnet apps. Its code is the JSON of a normalized
+// api.ShowRequest, so rendering goes through the same cache, limits and
+// timeout as evaluating jsonnet.
+var showEndpoint = jsonnetEndpoint{
+	name: "show",
+	op:   renderApp,
+}
+
+// writeTree writes the files of tree, in the shape of api.InitResponse, to
+// dir. Directories named .metadata are skipped, since the library is always
+// resolved from the library directory.
+func writeTree(dir string, tree map[string]interface{}) error {
+	for name, entry := range tree {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+			return badInputError(fmt.Sprintf("Invalid file name %q", name))
+		}
+		path := filepath.Join(dir, name)
+		switch entry := entry.(type) {
+		case string:
+			if err := ioutil.WriteFile(path, []byte(entry), 0600); err != nil {
+				return err
+			}
+		case map[string]interface{}:
+			if name == ".metadata" {
+				continue
+			}
+			if err := os.Mkdir(path, 0700); err != nil {
+				return err
+			}
+			if err := writeTree(path, entry); err != nil {
+				return err
+			}
+		default:
+			return badInputError(fmt.Sprintf("Invalid contents for %q - Expected a string, or an object for a directory", name))
+		}
+	}
+	return nil
+}
+
+// writeApp writes the components and environments directories of an app to
+// dir.
+func writeApp(dir string, components, environments map[string]interface{}) error {
+	for name, tree := range map[string]map[string]interface{}{
+		"components":   components,
+		"environments": environments,
+	} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			return err
+		}
+		if err := writeTree(filepath.Join(dir, name), tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// componentsExtCode builds the value of the __ksonnet/components ext var:
+// an object importing each component of the app in dir, as ks does.
+func componentsExtCode(dir string, components map[string]interface{}) string {
+	var names []string
+	for name, entry := range components {
+		if _, ok := entry.(string); ok && strings.HasSuffix(name, ".jsonnet") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var fields []string
+	for _, name := range names {
+		key, _ := json.Marshal(strings.TrimSuffix(name, ".jsonnet"))
+		path, _ := json.Marshal(filepath.Join(dir, "components", name))
+		fields = append(fields, fmt.Sprintf("%s: import %s", key, path))
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// flattenObjects collects the Kubernetes objects in the rendered value of a
+// component, which may be an object, a List, an array or an object of any
+// of these, the way ks does.
+func flattenObjects(value interface{}) ([]interface{}, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		objects := []interface{}{}
+		for _, v := range value {
+			flat, err := flattenObjects(v)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, flat...)
+		}
+		return objects, nil
+	case map[string]interface{}:
+		if _, ok := value["kind"]; ok {
+			if value["kind"] == "List" {
+				return flattenObjects(value["items"])
+			}
+			return []interface{}{value}, nil
+		}
+		var keys []string
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		objects := []interface{}{}
+		for _, k := range keys {
+			flat, err := flattenObjects(value[k])
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, flat...)
+		}
+		return objects, nil
+	}
+	return nil, badInputError(fmt.Sprintf("Expected Kubernetes objects, got %v", value))
+}
+
+// renderApp evaluates the environment of the app in code, a JSON
+// api.ShowRequest, returning a JSON object of the Kubernetes objects of each
+// component. Objects are put in the environment's namespace unless they have
+// one of their own.
+func renderApp(ctx context.Context, code string) (string, []LogLine, error) {
+	var req api.ShowRequest
+	if err := json.Unmarshal([]byte(code), &req); err != nil {
+		return "", nil, err
+	}
+	library, err := k8sLibrary(req.K8sVersion)
+	if err != nil {
+		return "", nil, badInputError(err.Error())
+	}
+	touchLibrary(library)
+
+	dir, err := ioutil.TempDir("", "ksonnet-app-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := writeApp(dir, req.Components, req.Environments); err != nil {
+		return "", nil, err
+	}
+
+	spec, err := readEnvSpec(req.Environment, req.Environments[req.Environment].(map[string]interface{}))
+	if err != nil {
+		return "", nil, err
+	}
+
+	envDir := filepath.Join(dir, "environments", req.Environment)
+	params, _ := json.Marshal(filepath.Join(envDir, "params.libsonnet"))
+	stdout, stderr, err := execJsonnet(ctx,
+		"-J", filepath.Join(config.LibraryDir, library),
+		"--ext-code", "__ksonnet/components="+componentsExtCode(dir, req.Components),
+		"--ext-code", "__ksonnet/params=import "+string(params),
+		filepath.Join(envDir, "main.jsonnet"))
+	errOutput, logs := parseStderr(stderr, err != nil)
+	if err != nil {
+		// Don't leak where the app was written
+		return strings.Replace(errOutput, dir+string(filepath.Separator), "", -1), logs, err
+	}
+
+	var rendered map[string]interface{}
+	if err := json.Unmarshal(stdout, &rendered); err != nil {
+		return "", logs, badInputError("The environment must evaluate to an object of components")
+	}
+	// CustomResourceDefinitions in any component can declare the kinds of
+	// objects in the others cluster-scoped
+	flattened := map[string][]interface{}{}
+	var all []interface{}
+	for name, value := range rendered {
+		objects, err := flattenObjects(value)
+		if err != nil {
+			return "", logs, err
+		}
+		flattened[name] = objects
+		all = append(all, objects...)
+	}
+	custom := customClusterScopedKinds(all)
+	components := map[string]interface{}{}
+	for name, objects := range flattened {
+		injectNamespace(objects, spec.Namespace, custom)
+		components[name] = objects
+	}
+	output, err := json.Marshal(components)
+	return string(output), logs, err
+}
+
+// normalizeShowRequest fills in the defaults of req and checks that it names
+// an environment of the app.
+func normalizeShowRequest(req *api.ShowRequest) error {
+	if req.Environment == "" {
+		req.Environment = defaultEnvironment
+	}
+	if req.K8sVersion == "" {
+		req.K8sVersion = defaultK8sVersion
+	}
+	if req.Format == "" {
+		req.Format = showYAML
+	}
+	if req.Format != showYAML && req.Format != showJSON {
+		return fmt.Errorf("Invalid format %q - Expected %q or %q", req.Format, showYAML, showJSON)
+	}
+	if err := validateName("environment", req.Environment); err != nil {
+		return err
+	}
+	env, ok := req.Environments[req.Environment].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("No environment %q in the app", req.Environment)
+	}
+	if _, ok := env["main.jsonnet"].(string); !ok {
+		return fmt.Errorf("Environment %q has no main.jsonnet", req.Environment)
+	}
+	if _, ok := env["spec.json"].(string); !ok {
+		return fmt.Errorf("Environment %q has no spec.json", req.Environment)
+	}
+	// Imports resolve against the library directory, so .metadata is left
+	// out of the cache key
+	delete(env, ".metadata")
+	return nil
+}
+
+// renderEnvironment renders the app in req, which normalizeShowRequest has
+// checked, mapping each component to its objects. If that fails, the result
+// to respond with is returned instead.
+func renderEnvironment(r *http.Request, req api.ShowRequest) (map[string][]interface{}, *CachedResult) {
+	code, _ := json.Marshal(req)
+	result := showEndpoint.evaluate(r.Context(), clientID(r), JsonnetRequest{Code: string(code)})
+	if result.Response.Error != nil {
+		return nil, &result
+	}
+
+	var components map[string][]interface{}
+	if err := json.Unmarshal([]byte(*result.Response.Output), &components); err != nil {
+		result := errorResult(http.StatusInternalServerError, err)
+		return nil, &result
+	}
+	return components, nil
+}
+
+// ksShow renders the components of an app for one of its environments, the
+// way `ks show` does.
+func ksShow(w http.ResponseWriter, r *http.Request) {
+	var req api.ShowRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	if err := normalizeShowRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	components, failed := renderEnvironment(r, req)
+	if failed != nil {
+		showEndpoint.write(w, r, *failed)
+		return
+	}
+	resp, err := showResponse(components, req.Format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// showResponse formats the objects of each component of a rendered app.
+func showResponse(components map[string][]interface{}, format string) (api.ShowResponse, error) {
+	resp := api.ShowResponse{Components: map[string]interface{}{}}
+	for name, objects := range components {
+		if format == showJSON {
+			resp.Components[name] = objects
+			continue
+		}
+		var docs []string
+		for _, object := range objects {
+			doc, err := yaml.Marshal(object)
+			if err != nil {
+				return resp, err
+			}
+			docs = append(docs, "---\n"+string(doc))
+		}
+		resp.Components[name] = strings.Join(docs, "")
+	}
+	return resp, nil
+}
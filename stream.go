@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const eventStreamMediaType = "text/event-stream"
+
+// StreamEventType is the kind of a StreamEvent.
+type StreamEventType string
+
+const (
+	// StreamQueued is sent once the request was accepted
+	StreamQueued StreamEventType = "queued"
+	// StreamStarted is sent when jsonnet starts running. Requests answered
+	// from the cache get it, and the trace and output events, replayed.
+	StreamStarted StreamEventType = "started"
+	// StreamTrace carries a line of std.trace output
+	StreamTrace StreamEventType = "trace"
+	// StreamOutput carries the size of the output once jsonnet succeeded
+	StreamOutput StreamEventType = "output"
+	// StreamResult carries the final result, and is always the last event
+	StreamResult StreamEventType = "result"
+)
+
+// enum lists the possible values, for the OpenAPI spec.
+func (StreamEventType) enum() []string {
+	return []string{
+		string(StreamQueued), string(StreamStarted), string(StreamTrace),
+		string(StreamOutput), string(StreamResult),
+	}
+}
+
+// StreamEvent is the data of a server-sent event reporting the progress of
+// an evaluation. The SSE event name is the same as Type.
+type StreamEvent struct {
+	Type   StreamEventType  `json:"type"`
+	Line   string           `json:"line,omitempty"`
+	Bytes  int              `json:"bytes,omitempty"`
+	Result *JsonnetResponse `json:"result,omitempty"`
+}
+
+// progressKey is the context key for the function progress is reported to.
+type progressKey struct{}
+
+// withProgress returns a context under which evaluation progress is reported
+// to report, which must be safe to call from several goroutines.
+func withProgress(ctx context.Context, report func(StreamEvent)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// reportProgress reports ev if anyone is listening on ctx.
+func reportProgress(ctx context.Context, ev StreamEvent) {
+	if report, ok := ctx.Value(progressKey{}).(func(StreamEvent)); ok {
+		report(ev)
+	}
+}
+
+// replayProgress reports the events a cached result was evaluated with, so
+// streaming clients see the same ones whether it came from the cache or not.
+func replayProgress(ctx context.Context, result CachedResult) {
+	reportProgress(ctx, StreamEvent{Type: StreamStarted})
+	for _, log := range result.Response.Logs {
+		if log.Kind != LogTrace {
+			continue
+		}
+		// Only the first line of a trace is streamed as it's written
+		line := strings.SplitN(log.Message, "\n", 2)[0]
+		if log.File != "" {
+			line = fmt.Sprintf("%s:%d %s", log.File, log.Line, line)
+		}
+		reportProgress(ctx, StreamEvent{Type: StreamTrace, Line: line})
+	}
+	if output := result.Response.Output; output != nil {
+		reportProgress(ctx, StreamEvent{Type: StreamOutput, Bytes: len(*output)})
+	}
+}
+
+// traceWriter reports the std.trace lines written to it as progress on ctx.
+type traceWriter struct {
+	ctx     context.Context
+	partial []byte
+}
+
+func (t *traceWriter) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(t.partial[:i])
+		t.partial = t.partial[i+1:]
+		if strings.HasPrefix(line, tracePrefix) {
+			reportProgress(t.ctx, StreamEvent{Type: StreamTrace, Line: strings.TrimPrefix(line, tracePrefix)})
+		}
+	}
+}
+
+// wantsEventStream reports whether the client prefers server-sent events to
+// a single response.
+func (e jsonnetEndpoint) wantsEventStream(r *http.Request) bool {
+	accepted := acceptedTypes(r)
+	return e.stream && len(accepted) > 0 && accepted[0] == eventStreamMediaType
+}
+
+// eventWriter sends server-sent events, one at a time.
+type eventWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+}
+
+func (s *eventWriter) send(ev StreamEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Fatalf("Failed to serialize stream event:\n%v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Write errors mean the client went away, which cancels the evaluation
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// serveEvents evaluates req, streaming its progress to the client as
+// server-sent events. The client closing the stream cancels the evaluation.
+func (e jsonnetEndpoint) serveEvents(w http.ResponseWriter, r *http.Request, client string, req JsonnetRequest) {
+	w.Header().Set("Content-Type", eventStreamMediaType)
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies from holding events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	events := &eventWriter{w: w}
+	events.send(StreamEvent{Type: StreamQueued})
+
+	ctx := withProgress(r.Context(), events.send)
+	result := e.evaluate(ctx, client, req)
+	events.send(StreamEvent{Type: StreamResult, Result: &result.Response})
+}
@@ -0,0 +1,77 @@
+#!/bin/bash
+# Checks that clients crossing an abuse threshold are banned until an operator
+# lifts the ban, and that the deny list blocks clients and code. The server
+# must run with --trusted-proxies 1, --abuse-max-too-large 2,
+# --max-workspaces-per-client 2 and --abuse-max-workspace-limited 1, and its
+# admin endpoints must be reachable on port 9102 of the same host.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+ADMIN="${HOST_PORT%:*}:9102/admin/denylist"
+
+CLIENT="203.0.113.$((RANDOM % 250 + 1))"
+OTHER="198.51.100.$((RANDOM % 250 + 1))"
+CODE="{abuse: ${RANDOM}}"
+VALID="$(jq -n --arg v "${CODE}" '{"code": $v}')"
+TOO_LARGE="$(jq -n --arg v "$(head -c 20000 /dev/zero | tr '\0' a)" '{"code": $v}')"
+
+# Evaluates the body $2 with X-Forwarded-For $1 and checks the status is $3
+eval_as() {
+    local code
+    code="$(curl -s -o /dev/null -w '%{http_code}' -X POST -H "X-Forwarded-For: $1" --data-raw "$2" "$HOST_PORT/api/v1/eval")"
+    if [[ "${code}" != "$3" ]]; then
+        echo "Evaluating as $1 answered HTTP ${code}, not $3" 1>&2
+        exit 1
+    fi
+}
+
+# Only the hop added by the trusted proxy identifies the client, so clients
+# can't spread their requests over made-up addresses
+eval_as "${CLIENT}" "${VALID}" 200
+eval_as "192.0.2.1, ${CLIENT}" "${TOO_LARGE}" 413
+eval_as "192.0.2.2, ${CLIENT}" "${TOO_LARGE}" 413
+eval_as "${CLIENT}" "${VALID}" 200
+eval_as "192.0.2.3, ${CLIENT}" "${TOO_LARGE}" 413
+eval_as "${CLIENT}" "${VALID}" 403
+eval_as "${CLIENT}, ${OTHER}" "${VALID}" 200
+
+# Removing the client from the deny list lifts the ban
+curl -sf -X DELETE --data-raw "{\"clients\": [\"${CLIENT}\"]}" "${ADMIN}" >/dev/null
+eval_as "${CLIENT}" "${VALID}" 200
+
+curl -sf -X POST --data-raw "{\"clients\": [\"${OTHER}\"]}" "${ADMIN}" >/dev/null
+curl -sf "${ADMIN}" | jq -e --arg c "${OTHER}" '.clients | index($c) != null' >/dev/null
+eval_as "${OTHER}" "${VALID}" 403
+eval_as "${CLIENT}" "${VALID}" 200
+curl -sf -X DELETE --data-raw "{\"clients\": [\"${OTHER}\"]}" "${ADMIN}" >/dev/null
+eval_as "${OTHER}" "${VALID}" 200
+
+HASH="$(echo -n "${CODE}" | sha256sum | cut -c1-8)"
+curl -sf -X POST --data-raw "{\"codeHashes\": [\"${HASH}*\"]}" "${ADMIN}" >/dev/null
+eval_as "${CLIENT}" "${VALID}" 403
+eval_as "${CLIENT}" "$(jq -n '{"code": "{allowed: true}"}')" 200
+curl -sf -X DELETE --data-raw "{\"codeHashes\": [\"${HASH}*\"]}" "${ADMIN}" >/dev/null
+eval_as "${CLIENT}" "${VALID}" 200
+
+# Going over the limit on workspaces more than once gets a client banned
+create_as() {
+    local code
+    code="$(curl -s -o /dev/null -w '%{http_code}' -X POST -H "X-Forwarded-For: $1" \
+        --data-raw '{"appName": "abuse", "server": "https://k8s.example.com"}' "$HOST_PORT/api/v1/workspaces")"
+    if [[ "${code}" != "$2" ]]; then
+        echo "Creating a workspace as $1 answered HTTP ${code}, not $2" 1>&2
+        exit 1
+    fi
+}
+create_as "${OTHER}" 201
+create_as "${OTHER}" 201
+create_as "${OTHER}" 429
+create_as "${CLIENT}" 201
+eval_as "${OTHER}" "${VALID}" 200
+create_as "${OTHER}" 429
+eval_as "${OTHER}" "${VALID}" 403
+curl -sf -X DELETE --data-raw "{\"clients\": [\"${OTHER}\"]}" "${ADMIN}" >/dev/null
+
+echo "Bans and the deny list work"
@@ -18,10 +18,37 @@ set +e
 "${DIR}/curl.sh" "${HOST_PORT}" "${DIR}"/valid.jsonnet || fail "valid.jsonnet failed"
 "${DIR}/curl.sh" "${HOST_PORT}" "${DIR}"/import.jsonnet || fail "import.jsonnet failed"
 "${DIR}/curl.sh" "${HOST_PORT}" "${DIR}"/sample.jsonnet || fail "sample.jsonnet failed"
+"${DIR}/curl-raw.sh" "${HOST_PORT}" "${DIR}"/sample.jsonnet || fail "raw sample.jsonnet failed"
+"${DIR}/format.sh" "${HOST_PORT}" || fail "format.sh failed"
+"${DIR}/batch.sh" "${HOST_PORT}" "${DIR}"/valid.jsonnet "${DIR}"/invalid.jsonnet "${DIR}"/valid.jsonnet "${DIR}"/sample.jsonnet \
+    || fail "batch.sh failed"
+"${DIR}/job.sh" "${HOST_PORT}" "${DIR}"/sample.jsonnet || fail "job sample.jsonnet failed"
+"${DIR}/sse.sh" "${HOST_PORT}" "${DIR}"/sample.jsonnet || fail "streamed sample.jsonnet failed"
+"${DIR}/trace.sh" "${HOST_PORT}" || fail "trace.sh failed"
+"${DIR}/validate.sh" "${HOST_PORT}" || fail "validate.sh failed"
+"${DIR}/order.sh" "${HOST_PORT}" || fail "order.sh failed"
+"${DIR}/library.sh" "${HOST_PORT}" || fail "library.sh failed"
+"${DIR}/convert.sh" "${HOST_PORT}" || fail "convert.sh failed"
+"${DIR}/init.sh" "${HOST_PORT}" || fail "init.sh failed"
+"${DIR}/show.sh" "${HOST_PORT}" || fail "show.sh failed"
+"${DIR}/env.sh" "${HOST_PORT}" || fail "env.sh failed"
+"${DIR}/param.sh" "${HOST_PORT}" || fail "param.sh failed"
+"${DIR}/diff.sh" "${HOST_PORT}" || fail "diff.sh failed"
+"${DIR}/apply.sh" "${HOST_PORT}" || fail "apply.sh failed"
+"${DIR}/workspace.sh" "${HOST_PORT}" || fail "workspace.sh failed"
+"${DIR}/archive.sh" "${HOST_PORT}" || fail "archive.sh failed"
+"${DIR}/generate.sh" "${HOST_PORT}" || fail "generate.sh failed"
+"${DIR}/prototypes.sh" "${HOST_PORT}" || fail "prototypes.sh failed"
+"${DIR}/grpc.sh" "${HOST_PORT}" || fail "grpc.sh failed"
 
 "${DIR}/curl.sh" "${HOST_PORT}" "${DIR}"/invalid.jsonnet && fail "invalid.jsonnet should have failed but did not"
 "${DIR}/curl.sh" "${HOST_PORT}" "${DIR}"/slow.jsonnet && fail "slow.jsonnet should have failed but did not"
 "${DIR}/curl.sh" "${HOST_PORT}" "${DIR}"/toobig.jsonnet && fail "toobig.jsonnet should have failed but did not"
+"${DIR}/curl-raw.sh" "${HOST_PORT}" "${DIR}"/invalid.jsonnet && fail "raw invalid.jsonnet should have failed but did not"
+"${DIR}/sse.sh" "${HOST_PORT}" "${DIR}"/invalid.jsonnet && fail "streamed invalid.jsonnet should have failed but did not"
+"${DIR}/job.sh" "${HOST_PORT}" "${DIR}"/invalid.jsonnet && fail "job invalid.jsonnet should have failed but did not"
+
+"${DIR}/openapi.sh" "${HOST_PORT}" || fail "openapi.sh failed"
 
 if [[ "${FAILED}" -eq "1" ]]; then
     exit 1
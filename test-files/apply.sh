@@ -0,0 +1,36 @@
+#!/bin/bash
+# Previews applying a Deployment, a Service and a ConfigMap over live objects
+# that kubectl apply created earlier.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+DEPLOYMENT='{"apiVersion": "apps/v1beta1", "kind": "Deployment", "metadata": {"name": "nginx"},
+  "spec": {"replicas": 2, "template": {"spec": {"containers": [{"name": "web", "image": "nginx:1.13.0",
+    "env": [{"name": "MODE", "value": "debug"}]}]}}}}'
+SERVICE='{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "nginx"},
+  "spec": {"clusterIP": "10.0.0.10", "ports": [{"port": 80}]}}'
+STALE='{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "stale"}, "data": {"a": "b"}}'
+
+REQUEST="$(jq -n --argjson deployment "${DEPLOYMENT}" --argjson service "${SERVICE}" --argjson stale "${STALE}" '
+  def live: .metadata.namespace = "default"
+    | .metadata.annotations["kubectl.kubernetes.io/last-applied-configuration"] = tojson;
+  {
+    "objects": [
+      ($deployment | .spec.replicas = 3 | .spec.template.spec.containers[0].env = []),
+      ($service | .spec.clusterIP = "10.0.0.11"),
+      {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings"}, "data": {"mode": "production"}}
+    ],
+    "live": {"apiVersion": "v1", "kind": "List", "items": [($deployment | live), ($service | live), ($stale | live)]}
+  }')"
+
+curl -sf -X POST "$HOST_PORT/api/v1/apply/preview" --data-raw "${REQUEST}" | jq -e '
+  (.objects | map(.action) == ["update", "update", "create"]) and
+  .objects[0].patchType == "application/strategic-merge-patch+json" and
+  .objects[0].patch.spec.replicas == 3 and
+  .objects[0].patch.spec.template.spec.containers[0].env == [{"name": "MODE", "$patch": "delete"}] and
+  (.objects[0].patch.metadata | has("namespace") | not) and
+  .objects[1].immutableChanges[0].path == ".spec.clusterIP" and
+  (.prune | map(.name) == ["stale"])' >/dev/null
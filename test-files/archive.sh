@@ -0,0 +1,38 @@
+#!/bin/bash
+# Downloads a workspace as an archive, with its library vendored, imports it
+# back as a new workspace without the library, and checks that an archive
+# with a path leading out of it is refused.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+TMP="$(mktemp -d)"
+trap 'rm -rf "${TMP}"' EXIT
+
+ID="$(curl -sf -X POST "$HOST_PORT/api/v1/workspaces" \
+    --data-raw '{"appName": "guestbook", "server": "https://k8s.example.com"}' | jq -r .id)"
+WS="$HOST_PORT/api/v1/workspaces/${ID}"
+curl -sf -X PUT "$WS/files?path=components/guestbook.jsonnet" --data-raw '{"contents": "{}\n"}' >/dev/null
+
+curl -sf "$WS/archive" -o "${TMP}/guestbook.tar.gz"
+tar tzf "${TMP}/guestbook.tar.gz" | grep -x 'guestbook/app.yaml' >/dev/null
+tar tzf "${TMP}/guestbook.tar.gz" | grep -x 'guestbook/components/guestbook.jsonnet' >/dev/null
+tar tzf "${TMP}/guestbook.tar.gz" | grep -x 'guestbook/environments/default/.metadata/k.libsonnet' >/dev/null
+curl -sf "$WS/archive?format=zip" -o "${TMP}/guestbook.zip"
+unzip -l "${TMP}/guestbook.zip" | grep 'guestbook/environments/default/spec.json' >/dev/null
+
+for ARCHIVE in guestbook.tar.gz guestbook.zip; do
+    curl -sf -X POST "$HOST_PORT/api/v1/workspaces/import" --data-binary "@${TMP}/${ARCHIVE}" \
+        | jq -e '.app.appName == "guestbook" and .app.components["guestbook.jsonnet"] == "{}\n"
+            and (.app.environments.default[".metadata"] | keys == ["library.json"])' >/dev/null
+done
+
+# Members leading out of the archive must be refused
+mkdir "${TMP}/app"
+touch "${TMP}/app/app.yaml"
+tar czPf "${TMP}/evil.tar.gz" -C "${TMP}/app" --transform 's,^,../,' app.yaml
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$HOST_PORT/api/v1/workspaces/import" \
+    --data-binary "@${TMP}/evil.tar.gz")"
+[[ "${CODE}" == "400" ]]
@@ -0,0 +1,16 @@
+#!/bin/bash
+# Evaluates the given files in one batch, checking that every file gets a
+# response, in order, and that only the responses of the files whose name
+# starts with "invalid" are errors.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+shift
+
+BODY="$(for f in "$@"; do jq -n --arg v "$(cat "$f")" '{"code": $v}'; done | jq -s '{"requests": .}')"
+EXPECTED="$(for f in "$@"; do [[ "$(basename "$f")" == invalid* ]] && echo true || echo false; done | jq -s -c .)"
+
+curl -sf -X POST --data-raw "${BODY}" "$HOST_PORT/api/v1/batch" \
+    | jq -e --argjson expected "${EXPECTED}" '[.responses[] | .error != null] == $expected'
@@ -0,0 +1,66 @@
+#!/bin/bash
+# Converts a Deployment and a ConfigMap to jsonnet with the default library,
+# and evaluates the code to get them back. Also converts a custom resource
+# with a generated library, whose builders take different arguments.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+YAML='apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels:
+        app: nginx
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.13.0
+        ports:
+        - name: www
+          containerPort: 80
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: production'
+
+RESPONSE="$(curl -sf -X POST "$HOST_PORT/api/v1/convert" --data-raw "$(jq -n --arg yaml "${YAML}" '{"yaml": $yaml}')")"
+echo "${RESPONSE}" | jq -e '[.objects[].kind] == ["Deployment", "ConfigMap"]' >/dev/null
+
+curl -sf -X POST "$HOST_PORT/api/v1/eval" --data-raw "$(echo "${RESPONSE}" | jq '{"code": .code, "library": .library}')" \
+    | jq -e '.output | contains("image: nginx:1.13.0") and contains("mode: production")' >/dev/null
+
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$HOST_PORT/api/v1/convert" --data-raw '{"yaml": "replicas: 2"}')"
+[[ "${CODE}" == "400" ]]
+
+# Generated libraries' builders only have a new() without arguments, which
+# objects are built with even when their kinds share names with ksonnet-lib's
+CRD='{"apiVersion": "apiextensions.k8s.io/v1beta1", "kind": "CustomResourceDefinition",
+  "metadata": {"name": "services.mesh.example.com"},
+  "spec": {"group": "mesh.example.com", "version": "v1", "names": {"kind": "Service", "plural": "services"},
+    "validation": {"openAPIV3Schema": {"properties": {"spec": {"properties": {
+      "ports": {"type": "array", "items": {"properties": {"name": {"type": "string"}, "containerPort": {"type": "integer"}}}}}}}}}}}'
+NAME="convert-test-$RANDOM"
+curl -sf -X POST "$HOST_PORT/api/v1/libraries" --data-raw "$(jq -n --arg name "${NAME}" --argjson crd "${CRD}" '{"name": $name, "crds": [$crd]}')" >/dev/null
+
+YAML='apiVersion: mesh.example.com/v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+  - name: www
+    containerPort: 80'
+RESPONSE="$(curl -sf -X POST "$HOST_PORT/api/v1/convert" \
+    --data-raw "$(jq -n --arg yaml "${YAML}" --arg name "${NAME}" '{"yaml": $yaml, "library": $name}')")"
+curl -sf -X DELETE "$HOST_PORT/api/v1/libraries/${NAME}" >/dev/null
+echo "${RESPONSE}" | jq -e '.objects[0].style == "constructor" and (.code | contains("service.new() +"))' >/dev/null
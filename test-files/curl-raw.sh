@@ -0,0 +1,9 @@
+#!/bin/bash
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+INPUT_FILE="$2"
+
+curl -sf -X POST -H 'Content-Type: application/jsonnet' -H 'Accept: application/yaml' --data-binary "@${INPUT_FILE}" "$HOST_PORT/api/v1/eval"; echo
@@ -6,4 +6,4 @@ set -o nounset
 HOST_PORT="$1"
 INPUT_FILE="$2"
 
-curl -sf -X POST --data-raw "$(jq -n --arg v "$(cat $INPUT_FILE)" '{"code": $v}')" "$HOST_PORT"; echo
+curl -sf -X POST --data-raw "$(jq -n --arg v "$(cat $INPUT_FILE)" '{"code": $v}')" "$HOST_PORT/api/v1/eval"; echo
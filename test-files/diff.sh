@@ -0,0 +1,27 @@
+#!/bin/bash
+# Scaffolds an app with two environments, overrides the replicas of a
+# component in one of them, and checks that the diff between them has just
+# that change.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+post() {
+    curl -sf -X POST --data-binary @- "$HOST_PORT/api/v1/$1"
+}
+
+APP="$(curl -sf -X POST "$HOST_PORT/api/v1/init" \
+    --data-raw '{"appName": "guestbook", "environment": "dev", "server": "https://k8s.example.com"}' \
+    | jq '.name = "prod" | .server = "https://k8s.example.com"' | post env/add \
+    | jq '.components["guestbook.jsonnet"] = "local params = std.extVar(\"__ksonnet/params\").components.guestbook;\n{apiVersion: \"apps/v1\", kind: \"Deployment\", metadata: {name: \"guestbook\"}, spec: {replicas: params.replicas}}\n"' \
+    | jq '.component = "guestbook" | .param = "replicas" | .value = 1' | post param/set \
+    | jq '.environment = "prod" | .component = "guestbook" | .param = "replicas" | .value = 3' | post param/set)"
+
+echo "${APP}" | jq '.environment = "dev" | .otherEnvironment = "prod"' | post diff \
+    | jq -e '
+        (.objects | map(del(.diff)) == [{
+            "apiVersion": "apps/v1", "kind": "Deployment", "namespace": "default", "name": "guestbook",
+            "status": "changed", "changes": [{"path": ".spec.replicas", "value": 1, "otherValue": 3}]}])
+        and (.diff | contains("-  replicas: 1\n+  replicas: 3\n"))' >/dev/null
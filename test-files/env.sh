@@ -0,0 +1,47 @@
+#!/bin/bash
+# Scaffolds an app, then adds, renames, lists and removes environments the
+# way `ks env` would, both in the request's app and in a workspace.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+env() {
+    curl -sf -X POST --data-binary @- "$HOST_PORT/api/v1/env/$1"
+}
+
+APP="$(curl -sf -X POST "$HOST_PORT/api/v1/init" \
+    --data-raw '{"appName": "guestbook", "server": "https://k8s.example.com"}')"
+
+APP="$(echo "${APP}" | jq '.name = "staging" | .server = "https://staging.example.com" | .namespace = "guestbook"' | env add)"
+APP="$(echo "${APP}" | jq '.name = "staging" | .newName = "prod" | .namespace = "guestbook-prod"' | env set)"
+
+echo "${APP}" | env list | jq -e '.environments == [
+    {"name": "default", "server": "https://k8s.example.com", "namespace": "default"},
+    {"name": "prod", "server": "https://staging.example.com", "namespace": "guestbook-prod"}]' >/dev/null
+
+echo "${APP}" | jq '.name = "default"' | env rm | jq -e '.environments | has("default") | not' >/dev/null
+
+CODE="$(echo "${APP}" | jq '.name = "prod" | .server = "https://k8s.example.com"' \
+    | curl -s -o /dev/null -w '%{http_code}' -X POST --data-binary @- "$HOST_PORT/api/v1/env/add")"
+[[ "${CODE}" == "409" ]]
+
+ID="$(curl -sf -X POST "$HOST_PORT/api/v1/workspaces" \
+    --data-raw '{"appName": "guestbook", "server": "https://k8s.example.com"}' | jq -r .id)"
+WS="$HOST_PORT/api/v1/workspaces/${ID}"
+
+curl -sf -X POST "$WS/env/add" --data-raw '{"name": "staging", "server": "https://staging.example.com", "namespace": "guestbook"}' \
+    | jq -e '.revision == 2 and (.app.environments | has("staging"))' >/dev/null
+curl -sf -X POST "$WS/env/set" --data-raw '{"name": "staging", "newName": "prod", "namespace": "guestbook-prod"}' \
+    | jq -e '.revision == 3' >/dev/null
+curl -sf -X POST "$WS/env/rm" --data-raw '{"name": "default"}' | jq -e '.revision == 4' >/dev/null
+curl -sf "$WS/env/list" | jq -e '.environments == [
+    {"name": "prod", "server": "https://staging.example.com", "namespace": "guestbook-prod"}]' >/dev/null
+curl -sf "$WS/revisions" | jq -e '.revisions[-1].message == "Remove environment default"' >/dev/null
+
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$WS/env/add" --data-raw '{"name": "prod", "server": "https://k8s.example.com"}')"
+[[ "${CODE}" == "409" ]]
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$WS/env/rm" --data-raw '{"name": "missing"}')"
+[[ "${CODE}" == "404" ]]
+curl -sf -X DELETE "$WS" >/dev/null
@@ -0,0 +1,19 @@
+#!/bin/bash
+# Checks that format reformats code with the jsonnet formatter and that
+# check accepts code that parses and refuses code that doesn't.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+curl -sf -X POST "$HOST_PORT/api/v1/format" --data-raw '{"code": "{a:1,   b: [1,2]}"}' \
+    | jq -e '.output == "{ a: 1, b: [1, 2] }\n"' >/dev/null
+curl -sf -X POST "$HOST_PORT/api/v1/check" --data-raw '{"code": "{a: std.foo()}"}' \
+    | jq -e '.output == ""' >/dev/null
+
+for ENDPOINT in format check; do
+    CODE="$(curl -s -o /tmp/format-body -w '%{http_code}' -X POST "$HOST_PORT/api/v1/${ENDPOINT}" --data-raw '{"code": "{a: "}')"
+    [[ "${CODE}" == "400" ]]
+    jq -e '.error != ""' /tmp/format-body >/dev/null
+done
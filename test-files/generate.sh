@@ -0,0 +1,16 @@
+#!/bin/bash
+# Generates a component from one of the bundled prototypes and checks that
+# the parameters were filled in, and that a missing one is refused.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+curl -sf -X POST "$HOST_PORT/api/v1/generate" \
+    --data-raw '{"name": "single-port-service", "parameters": {"name": "web", "targetPort": 8080}}' \
+    | jq -e '.components["web.jsonnet"] | contains("targetPort: 8080") and contains("port: 80,")' >/dev/null
+
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$HOST_PORT/api/v1/generate" \
+    --data-raw '{"name": "single-port-service", "parameters": {"name": "web"}}')"
+[[ "${CODE}" == "400" ]]
@@ -0,0 +1,36 @@
+#!/bin/bash
+# Calls the gRPC service with curl, checking that Evaluate answers with the
+# rendered YAML and an OK status, and that a grpc-timeout shorter than the
+# evaluation fails the call with DEADLINE_EXCEEDED.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+GRPC_PORT="${GRPC_PORT:-9090}"
+URL="https://${HOST_PORT%:*}:${GRPC_PORT}/ksonnet.playground.v1.Playground/Evaluate"
+
+# request writes a length-prefixed JsonnetRequest with the code in $1, which
+# must be shorter than 128 bytes, to $2.
+request() {
+    local code="$1"
+    printf "\\x00\\x00\\x00\\x00\\x$(printf '%02x' $(( ${#code} + 2 )))\\x0a\\x$(printf '%02x' ${#code})%s" "${code}" > "$2"
+}
+
+# call posts the request in $1 with the extra curl arguments that follow and
+# prints the response messages and trailers.
+call() {
+    local body="$1"
+    shift
+    curl -sk --http2 -X POST -H 'Content-Type: application/grpc' -H 'TE: trailers' \
+        --data-binary @"${body}" -D - "$@" "${URL}"
+}
+
+request '{replicas: 3}' /tmp/grpc-request
+call /tmp/grpc-request > /tmp/grpc-response
+grep -q 'replicas: 3' /tmp/grpc-response
+grep -qi '^grpc-status: 0' /tmp/grpc-response
+
+request 'local fib(n) = if n <= 1 then 1 else fib(n - 1) + fib(n - 2); fib(30)' /tmp/grpc-request
+call /tmp/grpc-request -H 'grpc-timeout: 10m' > /tmp/grpc-response
+grep -qi '^grpc-status: 4' /tmp/grpc-response
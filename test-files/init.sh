@@ -0,0 +1,22 @@
+#!/bin/bash
+# Scaffolds an app and checks that it has the files ks init writes, with the
+# requested environment, and that the base template can't be overwritten.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+curl -sf -X POST "$HOST_PORT/api/v1/init" \
+    --data-raw '{"appName": "guestbook", "environment": "dev", "server": "https://k8s.example.com", "namespace": "guestbook"}' \
+    | jq -e '
+        (.appYaml | contains("name: guestbook"))
+        and .components["params.libsonnet"] != null
+        and .environments["base.libsonnet"] != null
+        and (.environments.dev | has("main.jsonnet") and has("params.libsonnet"))
+        and (.environments.dev[".metadata"]["library.json"] | fromjson == {"name": "ksonnet.beta.2", "k8sVersion": "v1.7.0"})
+        and (.environments.dev["spec.json"] | fromjson == {"server": "https://k8s.example.com", "namespace": "guestbook"})' >/dev/null
+
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$HOST_PORT/api/v1/init" \
+    --data-raw '{"appName": "guestbook", "environment": "base.libsonnet", "server": "https://k8s.example.com"}')"
+[[ "${CODE}" == "400" ]]
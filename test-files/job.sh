@@ -0,0 +1,20 @@
+#!/bin/bash
+# Submits the given file as a job, polls it until it's done and prints the
+# result, failing if the result is an error.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+INPUT_FILE="$2"
+
+JOB="$(curl -sf -X POST --data-raw "$(jq -n --arg v "$(cat $INPUT_FILE)" '{"code": $v}')" "$HOST_PORT/api/v1/jobs")"
+ID="$(echo "${JOB}" | jq -r .id)"
+
+while [[ "$(echo "${JOB}" | jq -r .status)" == "running" ]]; do
+    sleep 1
+    JOB="$(curl -sf "$HOST_PORT/api/v1/jobs/${ID}")"
+done
+
+echo "${JOB}" | jq -e '.status == "done" and .result.error == null' >/dev/null
+echo "${JOB}" | jq -r .result.output
@@ -0,0 +1,65 @@
+#!/bin/bash
+# Generates a library from a CustomResourceDefinition, evaluates code that
+# builds a custom resource with it, and deletes it. Checks that others can't
+# delete it but operators can, that each owner can only keep so many, and
+# that specs with looping references or clashing kinds are rejected. The
+# admin endpoints must be reachable on port 9102 of the same host.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+ADMIN="${HOST_PORT%:*}:9102/admin/libraries"
+
+CRD='{"apiVersion": "apiextensions.k8s.io/v1beta1", "kind": "CustomResourceDefinition",
+  "metadata": {"name": "crontabs.stable.example.com"},
+  "spec": {"group": "stable.example.com", "version": "v1", "names": {"kind": "CronTab", "plural": "crontabs"},
+    "validation": {"openAPIV3Schema": {"properties": {"spec": {"properties": {
+      "cronSpec": {"type": "string"}, "replicas": {"type": "integer"}}}}}}}}'
+NAME="crontabs-test-$RANDOM"
+
+curl -sf -X POST "$HOST_PORT/api/v1/libraries" --data-raw "$(jq -n --arg name "${NAME}" --argjson crd "${CRD}" '{"name": $name, "crds": [$crd]}')" \
+    | jq -e --arg name "${NAME}" '.name == $name and (.files | index("k.libsonnet"))' >/dev/null
+
+CODE='local c = (import "k.libsonnet").stable.v1.cronTab;
+c.new() + c.mixin.metadata.name("backup") + c.mixin.spec.cronSpec("* * * * */5") + c.mixin.spec.replicas(2)'
+curl -sf -X POST "$HOST_PORT/api/v1/eval" \
+    --data-raw "$(jq -n --arg code "${CODE}" --arg name "${NAME}" '{"code": $code, "library": $name}')" \
+    | jq -e '.output | contains("apiVersion: stable.example.com/v1") and contains("replicas: 2")' >/dev/null
+
+# Only whoever generated the library can delete it
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X DELETE -H "Authorization: Bearer someone-else" "$HOST_PORT/api/v1/libraries/${NAME}")"
+[[ "${CODE}" == "403" ]]
+
+curl -sf -X DELETE "$HOST_PORT/api/v1/libraries/${NAME}" >/dev/null
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X DELETE "$HOST_PORT/api/v1/libraries/${NAME}")"
+[[ "${CODE}" == "404" ]]
+
+# Definitions that refer to themselves are rejected
+SWAGGER='{"swagger": "2.0", "definitions": {"Loop": {"$ref": "#/definitions/Loop"},
+  "io.k8s.api.core.v1.Thing": {"properties": {"apiVersion": {"type": "string"}, "kind": {"type": "string"},
+    "metadata": {"$ref": "#/definitions/Loop"}}}}}'
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$HOST_PORT/api/v1/libraries" \
+    --data-raw "$(jq -n --arg name "${NAME}" --argjson swagger "${SWAGGER}" '{"name": $name, "swagger": $swagger}')")"
+[[ "${CODE}" == "400" ]]
+
+# Kinds of groups sharing their first label, or defined twice, would clash
+generate() {
+    curl -s -o /dev/null -w '%{http_code}' -X POST -H "Authorization: Bearer $1" "$HOST_PORT/api/v1/libraries" \
+        --data-raw "$(jq -n --arg name "$2" --argjson crds "$3" '{"name": $name, "crds": $crds}')"
+}
+OTHER_GROUP="$(echo "${CRD}" | jq -c '.spec.group = "stable.example.org"')"
+[[ "$(generate "${NAME}" "${NAME}" "[${CRD}, ${OTHER_GROUP}]")" == "400" ]]
+[[ "$(generate "${NAME}" "${NAME}" "[${CRD}, ${CRD}]")" == "400" ]]
+
+# Each owner can keep only a few libraries, and operators can delete any
+KEY="library-test-$RANDOM"
+for i in 1 2 3; do
+    [[ "$(generate "${KEY}" "${NAME}-${i}" "[${CRD}]")" == "201" ]]
+done
+[[ "$(generate "${KEY}" "${NAME}-4" "[${CRD}]")" == "429" ]]
+for i in 1 2 3; do
+    curl -sf -X DELETE "${ADMIN}/${NAME}-${i}" | jq -e --arg name "${NAME}-${i}" '.name == $name' >/dev/null
+done
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X DELETE "${ADMIN}/ksonnet.beta.2")"
+[[ "${CODE}" == "409" ]]
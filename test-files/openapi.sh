@@ -0,0 +1,125 @@
+#!/bin/bash
+# Checks that the served OpenAPI spec and the handlers agree: every operation
+# in the spec is routed, documents its bodies and answers in the documented
+# shape, and methods missing from the spec are refused with a 405. Paths with
+# parameters are requested with a made-up value, so they may answer 404.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+SPEC="$(curl -sf "${HOST_PORT}/api/v1/openapi.json")"
+BASE="${HOST_PORT}$(echo "${SPEC}" | jq -r '.servers[0].url')"
+FAILED="0"
+
+fail() {
+    echo "$1" 1>&2
+    FAILED="1"
+}
+
+# Checks that the JSON body in $4 conforms to the schema documented for
+# method $1 and path $2: the request body if $3 is "request", else the
+# response at status code $3. An empty body must be documented as such.
+check_body() {
+    if [[ -z "$4" ]]; then
+        echo "${SPEC}" | jq -e --arg method "$1" --arg path "$2" --arg code "$3" \
+            '.paths[$path][$method].responses[$code] | . != null and .content == null' >/dev/null
+        return
+    fi
+    echo "${SPEC}" | jq -e \
+        --arg method "$1" --arg path "$2" --arg code "$3" --argjson body "$4" '
+        . as $spec
+        | def deref: if type == "object" and has("$ref") then $spec.components.schemas[.["$ref"] | split("/") | last] else . end;
+          def conforms($schema):
+            . as $v
+            | ($schema | deref) as $s
+            | if ($s | type) != "object" then true
+              elif $v == null then $s.nullable == true or $s.type == null and $s.allOf == null and $s.properties == null
+              elif $s.allOf != null then all($s.allOf[]; . as $sub | $v | conforms($sub))
+              elif $s.enum != null then any($s.enum[]; . == $v)
+              elif $s.type == "object" or $s.properties != null then
+                ($v | type) == "object"
+                and ((($s.required // []) - ($v | keys)) == [])
+                and all($v | to_entries[]; .key as $k | .value as $x
+                    | if $s.properties[$k] != null then $x | conforms($s.properties[$k])
+                      elif $s.additionalProperties != null then $x | conforms($s.additionalProperties)
+                      else false end)
+              elif $s.type == "array" then ($v | type) == "array" and all($v[]; conforms($s.items))
+              elif $s.type == "string" then ($v | type) == "string"
+              elif $s.type == "integer" then ($v | type) == "number" and ($v | floor) == $v
+              elif $s.type == "number" then ($v | type) == "number"
+              elif $s.type == "boolean" then ($v | type) == "boolean"
+              else true end;
+        .paths[$path][$method]
+        | (if $code == "request" then .requestBody else .responses | (.[$code] // .default) end)
+        | .content["application/json"].schema as $schema
+        | $schema != null and ($body | conforms($schema))' >/dev/null
+}
+
+# Sends method $1 to $3, a URL path for the spec path $2, with the JSON body
+# $4 if there is one, and checks that the status code is $5 and that the
+# bodies conform to the spec.
+check_call() {
+    local upper code args
+    upper="$(echo "$1" | tr a-z A-Z)"
+    args=(-s -o /tmp/openapi-body -w '%{http_code}' -X "${upper}")
+    if [[ -n "$4" ]]; then
+        check_body "$1" "$2" request "$4" || fail "${upper} $3: request body does not match the spec: $4"
+        args+=(--data-raw "$4")
+    fi
+    code="$(curl "${args[@]}" "${BASE}$3")"
+    [[ "${code}" == "$5" ]] || fail "${upper} $3: HTTP ${code}, not $5: $(cat /tmp/openapi-body)"
+    check_body "$1" "$2" "${code}" "$(cat /tmp/openapi-body)" \
+        || fail "${upper} $3: HTTP ${code} response does not match the spec: $(cat /tmp/openapi-body)"
+}
+
+while read -r method path; do
+    upper="$(echo "${method}" | tr a-z A-Z)"
+
+    echo "${SPEC}" | jq -e --arg m "${method}" --arg p "${path}" \
+        '.paths[$p][$m].responses | to_entries | any((.key | startswith("2")) and .value.content != null or .key == "101")' >/dev/null \
+        || fail "${upper} ${path}: no documented response schema"
+
+    args=(-s -g -o /tmp/openapi-body -w '%{http_code}' -X "${upper}")
+    if [[ "${upper}" != "GET" ]]; then
+        args+=(--data-raw '{}')
+    fi
+    code="$(curl "${args[@]}" "${BASE}${path}")"
+    if [[ "${code}" == "405" || "${code}" == "404" && "${path}" != *"{"* ]]; then
+        fail "${upper} ${path}: documented but not routed (HTTP ${code})"
+        continue
+    fi
+    check_body "${method}" "${path}" "${code}" "$(cat /tmp/openapi-body)" \
+        || fail "${upper} ${path}: HTTP ${code} response does not match the spec: $(cat /tmp/openapi-body)"
+done < <(echo "${SPEC}" | jq -r '.paths | to_entries[] | .key as $p | .value | keys[] | "\(.) \($p)"')
+
+# Real requests and their answers must conform too
+check_call post /eval /eval '{"code": "{a: [1, \"b\", null]}"}' 200
+check_call post /eval /eval '{"code": "{a: "}' 400
+check_call post /format /format '{"code": "{a:1}"}' 200
+check_call post /check /check '{"code": "{a: 1}"}' 200
+check_call post /batch /batch '{"requests": [{"code": "{a: 1}"}, {"code": "{a: "}]}' 200
+check_call post /init /init '{"appName": "openapi", "environment": "dev", "server": "https://k8s.example.com", "namespace": "openapi"}' 200
+check_call post /jobs /jobs '{"code": "{a: 1}"}' 202
+check_call get "/jobs/{id}" "/jobs/$(jq -r .id /tmp/openapi-body)" "" 200
+check_call get /prototypes /prototypes "" 200
+check_call get /libraries /libraries "" 200
+check_call post /workspaces /workspaces '{"appName": "openapi", "server": "https://k8s.example.com"}' 201
+WS="/workspaces/$(jq -r .id /tmp/openapi-body)"
+check_call put "/workspaces/{id}/files" "${WS}/files?path=components/openapi.jsonnet" '{"contents": "{}\n"}' 200
+check_call get "/workspaces/{id}/revisions" "${WS}/revisions" "" 200
+check_call delete "/workspaces/{id}" "${WS}" "" 200
+
+# Any method the spec doesn't list for a path must be refused
+while read -r method path; do
+    code="$(curl -s -g -o /dev/null -w '%{http_code}' -X "${method}" "${BASE}${path}")"
+    [[ "${code}" == "405" ]] || fail "${method} ${path}: undocumented method answered with HTTP ${code}, not 405"
+done < <(echo "${SPEC}" | jq -r '.paths | to_entries[] | .key as $p
+    | (["get", "post", "put", "delete", "patch"] - (.value | keys))[]
+    | "\(ascii_upcase) \($p)"')
+
+if [[ "${FAILED}" -eq "1" ]]; then
+    exit 1
+fi
+echo "OpenAPI spec matches the handlers"
@@ -0,0 +1,26 @@
+#!/bin/bash
+# Evaluates code rendering objects out of apply order, and checks that asking
+# for the order sorts them.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+CODE='{
+  deployment: {apiVersion: "apps/v1beta1", kind: "Deployment", metadata: {name: "web", namespace: "shop"}},
+  crontab: {apiVersion: "stable.example.com/v1", kind: "CronTab", metadata: {name: "backup", namespace: "shop"}},
+  crd: {
+    apiVersion: "apiextensions.k8s.io/v1beta1",
+    kind: "CustomResourceDefinition",
+    metadata: {name: "crontabs.stable.example.com"},
+    spec: {group: "stable.example.com", version: "v1", names: {kind: "CronTab", plural: "crontabs"}},
+  },
+  namespace: {apiVersion: "v1", kind: "Namespace", metadata: {name: "shop"}},
+  settings: {apiVersion: "v1", kind: "ConfigMap", metadata: {name: "settings", namespace: "shop"}},
+}'
+
+curl -sf -X POST "$HOST_PORT/api/v1/eval" --data-raw "$(jq -n --arg code "${CODE}" '{"code": $code, "order": true}')" \
+    | jq -e '(.order | map(.kind) == ["Namespace", "CustomResourceDefinition", "ConfigMap", "Deployment", "CronTab"])
+        and (.order | all(.reason != ""))
+        and (.output | startswith("apiVersion: v1\nitems:\n- apiVersion: v1\n  kind: Namespace"))' >/dev/null
@@ -0,0 +1,32 @@
+#!/bin/bash
+# Scaffolds an app with two environments, sets a component parameter globally
+# and overrides it in one of them, then checks the edited params files, the
+# effective values and the diff between the environments.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+post() {
+    curl -sf -X POST --data-binary @- "$HOST_PORT/api/v1/$1"
+}
+
+APP="$(curl -sf -X POST "$HOST_PORT/api/v1/init" \
+    --data-raw '{"appName": "guestbook", "environment": "dev", "server": "https://k8s.example.com"}' \
+    | jq '.name = "prod" | .server = "https://k8s.example.com"' | post env/add \
+    | jq '.components["guestbook.jsonnet"] = "{}"')"
+
+APP="$(echo "${APP}" | jq '.component = "guestbook" | .param = "replicas" | .value = 2' | post param/set)"
+APP="$(echo "${APP}" | jq '.environment = "dev" | .component = "guestbook" | .param = "replicas" | .value = 5' | post param/set)"
+
+# The comments of the scaffolded files are kept
+echo "${APP}" | jq -e '
+    (.components["params.libsonnet"] | contains("guestbook: { replicas: 2 }") and contains("// Component-level parameters"))
+    and (.environments.dev["params.libsonnet"] | contains("guestbook +: { replicas: 5 }") and contains("// Insert component parameter overrides here"))' >/dev/null
+
+echo "${APP}" | jq '.environment = "prod"' | post param/list \
+    | jq -e '.params == [{"component": "guestbook", "param": "replicas", "value": 2}]' >/dev/null
+
+echo "${APP}" | jq '.environment = "dev" | .otherEnvironment = "prod"' | post param/diff \
+    | jq -e '.diffs == [{"component": "guestbook", "param": "replicas", "value": 5, "otherValue": 2}]' >/dev/null
@@ -0,0 +1,14 @@
+#!/bin/bash
+# Checks that the bundled prototypes can be found by searching, and that the
+# schema of their parameters marks the required ones.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+curl -sf "$HOST_PORT/api/v1/prototypes?q=service+label" \
+    | jq -e '.prototypes | map(.name) == ["io.ksonnet.pkg.single-port-service"]' >/dev/null
+
+curl -sf "$HOST_PORT/api/v1/prototypes/single-port-service" \
+    | jq -e '.paramsSchema.required == ["name", "targetPort"] and .paramsSchema.properties.port.default == 80' >/dev/null
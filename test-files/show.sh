@@ -0,0 +1,26 @@
+#!/bin/bash
+# Scaffolds an app, adds a component whose name comes from its params with an
+# override in the environment, and checks that it renders with the override in
+# the environment's namespace. A custom resource declared cluster-scoped by a
+# CustomResourceDefinition in another component is left without one.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+APP="$(curl -sf -X POST "$HOST_PORT/api/v1/init" \
+    --data-raw '{"appName": "guestbook", "environment": "dev", "server": "https://k8s.example.com", "namespace": "guestbook"}')"
+
+APP="$(echo "${APP}" | jq '
+    .components["guestbook.jsonnet"] = "local params = std.extVar(\"__ksonnet/params\").components.guestbook;\n{apiVersion: \"v1\", kind: \"Service\", metadata: {name: params.name}}\n"
+    | .components["params.libsonnet"] = "{global: {}, components: {guestbook: {name: \"guestbook\"}}}\n"
+    | .environments.dev["params.libsonnet"] = "local params = import \"../../components/params.libsonnet\";\nparams + {components +: {guestbook +: {name: \"guestbook-dev\"}}}\n"
+    | .components["crontabs.jsonnet"] = "{apiVersion: \"apiextensions.k8s.io/v1beta1\", kind: \"CustomResourceDefinition\", metadata: {name: \"crontabs.stable.example.com\"}, spec: {group: \"stable.example.com\", version: \"v1\", scope: \"Cluster\", names: {kind: \"CronTab\", plural: \"crontabs\"}}}\n"
+    | .components["backup.jsonnet"] = "{apiVersion: \"stable.example.com/v1\", kind: \"CronTab\", metadata: {name: \"backup\"}}\n"
+    | .environment = "dev"
+    | .format = "json"')"
+
+echo "${APP}" | curl -sf -X POST --data-binary @- "$HOST_PORT/api/v1/show" \
+    | jq -e '.components.guestbook == [{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "guestbook-dev", "namespace": "guestbook"}}]
+        and .components.backup == [{"apiVersion": "stable.example.com/v1", "kind": "CronTab", "metadata": {"name": "backup"}}]'
@@ -0,0 +1,16 @@
+#!/bin/bash
+# Evaluates the given file as a stream of server-sent events, checking that
+# the stream starts with a queued event and ends with a successful result.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+INPUT_FILE="$2"
+
+EVENTS="$(curl -sfN -H 'Accept: text/event-stream' -X POST \
+    --data-raw "$(jq -n --arg v "$(cat $INPUT_FILE)" '{"code": $v}')" "$HOST_PORT/api/v1/eval" \
+    | sed -n 's/^data: //p' | jq -s -c .)"
+
+echo "${EVENTS}" | jq -e '.[0].type == "queued" and .[-1].type == "result" and .[-1].result.error == null' >/dev/null
+echo "${EVENTS}" | jq -r '.[-1].result.output'
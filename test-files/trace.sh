@@ -0,0 +1,24 @@
+#!/bin/bash
+# Evaluates code that calls std.trace, checking that the jsonnet in the image
+# supports it: the message is in the response's logs and streamed as a trace
+# event, also when the result comes from the cache.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+CODE='std.trace("tracing %d" % 42, {replicas: 3})'
+
+curl -sf -X POST "$HOST_PORT/api/v1/eval" --data-raw "$(jq -n --arg code "${CODE}" '{"code": $code}')" \
+    | jq -e '(.output | contains("replicas: 3"))
+        and (.logs | length == 1) and .logs[0].kind == "trace" and .logs[0].message == "tracing 42"' >/dev/null
+
+# The second request is answered from the cache, which replays the events
+for i in 1 2; do
+    curl -sfN -H 'Accept: text/event-stream' -X POST \
+        --data-raw "$(jq -n --arg code "${CODE} + {}" '{"code": $code}')" "$HOST_PORT/api/v1/eval" \
+        | sed -n 's/^data: //p' \
+        | jq -s -e 'map(.type) == ["queued", "started", "trace", "output", "result"]
+            and (.[2].line | contains("tracing 42"))' >/dev/null
+done
@@ -0,0 +1,22 @@
+#!/bin/bash
+# Evaluates a Deployment with a misspelled field, checking that validation
+# reports it and the missing field it was meant to be, and that valid objects
+# have no diagnostics.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+CODE='{apiVersion: "apps/v1beta1", kind: "Deployment", metadata: {name: "gb"}, spec: {replicas: 1, template: {spec: {contianers: [{name: "gb", image: "nginx"}]}}}}'
+curl -sf -X POST "$HOST_PORT/api/v1/eval" --data-raw "$(jq -n --arg code "${CODE}" '{"code": $code, "validate": true}')" \
+    | jq -e '.diagnostics | map(.path) == [".spec.template.spec.contianers", ".spec.template.spec.containers"]' >/dev/null
+
+CODE='{apiVersion: "v1", kind: "ConfigMap", metadata: {name: "x"}}'
+curl -sf -X POST "$HOST_PORT/api/v1/eval" --data-raw "$(jq -n --arg code "${CODE}" '{"code": $code, "validate": true, "k8sVersion": "v1.7.0"}')" \
+    | jq -e '.output != null and .diagnostics == null' >/dev/null
+
+CODE='{apiVersion: "v1", kind: "ConfigMap", metadata: {name: "x"}}'
+HTTP_CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "$HOST_PORT/api/v1/eval" \
+    --data-raw "$(jq -n --arg code "${CODE}" '{"code": $code, "validate": true, "k8sVersion": "v0.0.1"}')")"
+[[ "${HTTP_CODE}" == "400" ]]
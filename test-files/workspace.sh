@@ -0,0 +1,27 @@
+#!/bin/bash
+# Creates a workspace, adds and renames a component, and checks the revisions
+# it made, the diff of the rename and rolling it back.
+set -o errexit
+set -o pipefail
+set -o nounset
+
+HOST_PORT="$1"
+
+ID="$(curl -sf -X POST "$HOST_PORT/api/v1/workspaces" \
+    --data-raw '{"appName": "guestbook", "server": "https://k8s.example.com"}' | jq -r .id)"
+WS="$HOST_PORT/api/v1/workspaces/${ID}"
+
+curl -sf -X PUT "$WS/files?path=components/guestbook.jsonnet" --data-raw '{"contents": "{}\n"}' >/dev/null
+curl -sf -X POST "$WS/rename" --data-raw '{"component": "guestbook", "newName": "frontend"}' \
+    | jq -e '.revision == 3 and .app.components["frontend.jsonnet"] == "{}\n"' >/dev/null
+
+curl -sf "$WS/revisions" | jq -e '.revisions | map(.number) == [1, 2, 3]' >/dev/null
+curl -sf "$WS/diff" \
+    | jq -e '.files | map([.path, .status]) == [["components/frontend.jsonnet", "added"], ["components/guestbook.jsonnet", "removed"]]' >/dev/null
+
+curl -sf -X POST "$WS/rollback" --data-raw '{"revision": 2}' | jq -e '.revision == 4' >/dev/null
+curl -sf "$WS/files?path=components/guestbook.jsonnet" | jq -e '.contents == "{}\n"' >/dev/null
+
+curl -sf -X DELETE "$WS" >/dev/null
+CODE="$(curl -s -o /dev/null -w '%{http_code}' "$WS")"
+[[ "${CODE}" == "404" ]]
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+)
+
+// Diagnostic is a problem found validating the output of code, at the path of
+// the field it's about, e.g. .spec.template.spec.containers[0].image.
+type Diagnostic struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// schemaNode is a schema in a Kubernetes OpenAPI (swagger 2.0) spec. Only the
+// parts needed to validate objects are kept.
+type schemaNode struct {
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Ref                  string                 `json:"$ref"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	AdditionalProperties *schemaNode            `json:"additionalProperties"`
+	Items                *schemaNode            `json:"items"`
+	Required             []string               `json:"required"`
+	GroupVersionKinds    []struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"x-kubernetes-group-version-kind"`
+}
+
+// k8sSchema is the OpenAPI spec of a Kubernetes version, with its definitions
+// indexed by the apiVersion and kind of the objects they describe.
+type k8sSchema struct {
+	Definitions map[string]*schemaNode `json:"definitions"`
+	kinds       map[string]string
+}
+
+// kindKey is the key of a kind in k8sSchema.kinds. Groups are only matched on
+// their first label, since definition names have e.g. rbac where apiVersions
+// have rbac.authorization.k8s.io.
+func kindKey(group, version, kind string) string {
+	if i := strings.Index(group, "."); i >= 0 {
+		group = group[:i]
+	}
+	return group + "/" + version + "/" + kind
+}
+
+// apiVersionKey returns the kindKey of objects of the given apiVersion and
+// kind.
+func apiVersionKey(apiVersion, kind string) string {
+	group, version := "", apiVersion
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		group, version = apiVersion[:i], apiVersion[i+1:]
+	}
+	return kindKey(group, version, kind)
+}
+
+// indexKinds fills in s.kinds. Definitions are known by their
+// x-kubernetes-group-version-kind where the spec has it, and otherwise by
+// their name, such as io.k8s.kubernetes.pkg.apis.apps.v1beta1.Deployment, in
+// which the core group is api or core.
+func (s *k8sSchema) indexKinds() {
+	s.kinds = map[string]string{}
+	for name := range s.Definitions {
+		parts := strings.Split(name, ".")
+		if len(parts) < 3 {
+			continue
+		}
+		group := parts[len(parts)-3]
+		if group == "api" || group == "core" {
+			group = ""
+		}
+		s.kinds[kindKey(group, parts[len(parts)-2], parts[len(parts)-1])] = name
+	}
+	for name, def := range s.Definitions {
+		for _, gvk := range def.GroupVersionKinds {
+			s.kinds[kindKey(gvk.Group, gvk.Version, gvk.Kind)] = name
+		}
+	}
+}
+
+// k8sSchemas caches the specs read from the schema directory by Kubernetes
+// version.
+var k8sSchemas = struct {
+	sync.Mutex
+	byVersion map[string]*k8sSchema
+}{byVersion: map[string]*k8sSchema{}}
+
+// loadK8sSchema returns the OpenAPI spec of the given Kubernetes version, read
+// from <version>.json in the schema directory the first time it's needed.
+func loadK8sSchema(version string) (*k8sSchema, error) {
+	if err := validateName("Kubernetes version", version); err != nil {
+		return nil, badInputError(err.Error())
+	}
+	k8sSchemas.Lock()
+	defer k8sSchemas.Unlock()
+	if s, ok := k8sSchemas.byVersion[version]; ok {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(config.SchemaDir, version+".json"))
+	if os.IsNotExist(err) {
+		return nil, badInputError(fmt.Sprintf("No OpenAPI spec to validate against for Kubernetes version %q", version))
+	} else if err != nil {
+		return nil, err
+	}
+	var s k8sSchema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("Invalid OpenAPI spec for Kubernetes version %q: %v", version, err)
+	}
+	s.indexKinds()
+	k8sSchemas.byVersion[version] = &s
+	return &s, nil
+}
+
+// jsonType names the JSON type of value for messages.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+// validateObjects appends problems with the Kubernetes objects in value,
+// found at path, to diags. Like flattenObjects, it looks for objects in
+// Lists, arrays and objects of any of these.
+func (s *k8sSchema) validateObjects(path string, value interface{}, diags []Diagnostic) []Diagnostic {
+	switch value := value.(type) {
+	case []interface{}:
+		for i, v := range value {
+			diags = s.validateObjects(path+"["+strconv.Itoa(i)+"]", v, diags)
+		}
+		return diags
+	case map[string]interface{}:
+		kind, hasKind := value["kind"]
+		if !hasKind {
+			var keys []string
+			for k := range value {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				diags = s.validateObjects(fieldPath(path, k), value[k], diags)
+			}
+			return diags
+		}
+		if kind == "List" {
+			return s.validateObjects(fieldPath(path, "items"), value["items"], diags)
+		}
+		apiVersion, _ := value["apiVersion"].(string)
+		kindName, _ := kind.(string)
+		if apiVersion == "" || kindName == "" {
+			return append(diags, Diagnostic{rootPath(path), "Kubernetes objects must have a string apiVersion and kind"})
+		}
+		def, ok := s.kinds[apiVersionKey(apiVersion, kindName)]
+		if !ok {
+			return append(diags, Diagnostic{fieldPath(path, "kind"), fmt.Sprintf("Unknown kind %q in apiVersion %q", kindName, apiVersion)})
+		}
+		return s.validate(path, value, &schemaNode{Ref: "#/definitions/" + def}, diags)
+	}
+	return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected Kubernetes objects, got %s", jsonType(value))})
+}
+
+// validate appends the ways value, found at path, doesn't match node to
+// diags. Fields set to null are left out of objects when they're sent, so
+// they're always valid.
+func (s *k8sSchema) validate(path string, value interface{}, node *schemaNode, diags []Diagnostic) []Diagnostic {
+	if value == nil {
+		return diags
+	}
+	seen := map[string]bool{}
+	for node.Ref != "" {
+		name := strings.TrimPrefix(node.Ref, "#/definitions/")
+		def, ok := s.Definitions[name]
+		if !ok {
+			return diags
+		}
+		if seen[name] {
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Can't validate against definition %q, which refers to itself", name)})
+		}
+		seen[name] = true
+		// Quantities are strings in the spec, but the API also takes numbers
+		if _, isNumber := value.(float64); isNumber && strings.HasSuffix(name, ".Quantity") {
+			return diags
+		}
+		node = def
+	}
+
+	switch node.Type {
+	case "object", "":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			if node.Type == "" && node.Properties == nil {
+				// No schema, like RawExtension
+				return diags
+			}
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected an object, got %s", jsonType(value))})
+		}
+		var keys []string
+		for k := range object {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := node.Properties[k]; ok {
+				diags = s.validate(fieldPath(path, k), object[k], prop, diags)
+			} else if node.AdditionalProperties != nil {
+				diags = s.validate(fieldPath(path, k), object[k], node.AdditionalProperties, diags)
+			} else if node.Properties != nil {
+				diags = append(diags, Diagnostic{fieldPath(path, k), fmt.Sprintf("Unknown field %q", k)})
+			}
+		}
+		for _, k := range node.Required {
+			if _, ok := object[k]; !ok {
+				diags = append(diags, Diagnostic{fieldPath(path, k), fmt.Sprintf("Missing required field %q", k)})
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected an array, got %s", jsonType(value))})
+		}
+		if node.Items != nil {
+			for i, v := range array {
+				diags = s.validate(path+"["+strconv.Itoa(i)+"]", v, node.Items, diags)
+			}
+		}
+	case "string":
+		_, isString := value.(string)
+		_, isNumber := value.(float64)
+		if !isString && !(isNumber && node.Format == "int-or-string") {
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected a string, got %s", jsonType(value))})
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected a number, got %s", jsonType(value))})
+		}
+		if node.Type == "integer" && n != float64(int64(n)) {
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected an integer, got %v", n)})
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(diags, Diagnostic{rootPath(path), fmt.Sprintf("Expected a boolean, got %s", jsonType(value))})
+		}
+	}
+	return diags
+}
+
+// rootPath is path, or "." for the whole output.
+func rootPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+// validateResult checks the Kubernetes objects in the YAML output of a
+// successful result against the OpenAPI spec of the given Kubernetes
+// version, adding what it finds to the response's diagnostics.
+func validateResult(result CachedResult, k8sVersion string) CachedResult {
+	if result.Response.Output == nil {
+		return result
+	}
+	if k8sVersion == "" {
+		k8sVersion = defaultK8sVersion
+	}
+	schema, err := loadK8sSchema(k8sVersion)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(badInputError); ok {
+			status = http.StatusBadRequest
+		}
+		failed := errorResult(status, err)
+		failed.Response.Logs = result.Response.Logs
+		return failed
+	}
+
+	output, err := yaml.YAMLToJSON([]byte(*result.Response.Output))
+	var value interface{}
+	if err == nil {
+		err = json.Unmarshal(output, &value)
+	}
+	if err != nil {
+		failed := errorResult(http.StatusInternalServerError, err)
+		failed.Response.Logs = result.Response.Logs
+		return failed
+	}
+	result.Response.Diagnostics = schema.validateObjects("", value, []Diagnostic{})
+	return result
+}
@@ -0,0 +1,871 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/heptio/ksonnet-playground/api"
+)
+
+var (
+	workspaces           *workspaceStore
+	errWorkspaceNotFound = errors.New("No such workspace - it may have expired")
+	errTooManyWorkspaces = errors.New("Too many workspaces, please try again later")
+	errOwnWorkspaces     = errors.New("You have too many workspaces, please delete one first")
+	errRevisionNotFound  = errors.New("No such revision - only the latest revisions are kept")
+	errComponentExists   = errors.New("Component already exists")
+	errWorkspaceTooLarge = errors.New("The app would be larger than the size limit for apps")
+)
+
+// Workspace is an app kept on the server, so that clients can change it a
+// file at a time instead of sending all of it with every request. Revision
+// is the number of its current revision, and it's forgotten at Expires
+// unless it's used before then.
+type Workspace struct {
+	ID       string           `json:"id"`
+	Revision int              `json:"revision"`
+	Created  time.Time        `json:"created"`
+	Expires  time.Time        `json:"expires"`
+	App      api.InitResponse `json:"app"`
+}
+
+// Revision is a version of a workspace. Every change to a workspace makes a
+// new one, with a Message saying what changed.
+type Revision struct {
+	Number  int       `json:"number"`
+	Created time.Time `json:"created"`
+	Message string    `json:"message"`
+}
+
+// RevisionsResponse lists the revisions a workspace keeps, oldest first.
+type RevisionsResponse struct {
+	Revisions []Revision `json:"revisions"`
+}
+
+// WorkspaceFile is a file of a workspace. Path is relative to the root of
+// the app, e.g. app.yaml or components/guestbook.jsonnet.
+type WorkspaceFile struct {
+	Path     string `json:"path"`
+	Contents string `json:"contents"`
+}
+
+// RenameRequest renames a component of a workspace, along with its
+// parameters.
+type RenameRequest struct {
+	Component string `json:"component"`
+	NewName   string `json:"newName"`
+}
+
+// WorkspaceEnvRequest adds, updates or removes an environment of a
+// workspace, with the same fields as api.EnvRequest.
+type WorkspaceEnvRequest struct {
+	Name       string `json:"name"`
+	Server     string `json:"server"`
+	Namespace  string `json:"namespace"`
+	K8sVersion string `json:"k8sVersion"`
+	NewName    string `json:"newName"`
+}
+
+// WorkspaceShowRequest renders a workspace, with the same options as
+// api.ShowRequest.
+type WorkspaceShowRequest struct {
+	Environment string `json:"environment"`
+	K8sVersion  string `json:"k8sVersion"`
+	Format      string `json:"format"`
+}
+
+// RollbackRequest makes a new revision of a workspace with the app as it was
+// at an earlier Revision.
+type RollbackRequest struct {
+	Revision int `json:"revision"`
+}
+
+// FileDiff is a file that differs between two revisions of a workspace.
+// Status is "added", "removed" or "changed", and Diff is a unified diff of
+// its contents.
+type FileDiff struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Diff   string `json:"diff"`
+}
+
+// RevisionDiffResponse lists the files that differ between the From and To
+// revisions of a workspace, sorted by path, along with the unified diff of
+// all of them.
+type RevisionDiffResponse struct {
+	From  int        `json:"from"`
+	To    int        `json:"to"`
+	Files []FileDiff `json:"files"`
+	Diff  string     `json:"diff"`
+}
+
+// storedRevision is a revision along with the app as it was.
+type storedRevision struct {
+	Revision
+	app api.InitResponse
+}
+
+// storedWorkspace is a workspace and the revisions it keeps, oldest first.
+// The last revision is the current one.
+type storedWorkspace struct {
+	id        string
+	client    string
+	created   time.Time
+	lastUsed  time.Time
+	revisions []storedRevision
+}
+
+func (ws *storedWorkspace) current() storedRevision {
+	return ws.revisions[len(ws.revisions)-1]
+}
+
+// workspace returns a copy of the current state of ws.
+func (ws *storedWorkspace) workspace() Workspace {
+	current := ws.current()
+	return Workspace{
+		ID:       ws.id,
+		Revision: current.Number,
+		Created:  ws.created,
+		Expires:  ws.lastUsed.Add(config.WorkspaceIdle),
+		App:      copyApp(current.app),
+	}
+}
+
+// copyApp returns a deep copy of app, which changes to the copy leave alone.
+func copyApp(app api.InitResponse) api.InitResponse {
+	app.Components = copyTree(app.Components)
+	app.Environments = copyTree(app.Environments)
+	return app
+}
+
+func copyTree(tree map[string]interface{}) map[string]interface{} {
+	if tree == nil {
+		return nil
+	}
+	copied := map[string]interface{}{}
+	for name, entry := range tree {
+		if dir, ok := entry.(map[string]interface{}); ok {
+			entry = copyTree(dir)
+		}
+		copied[name] = entry
+	}
+	return copied
+}
+
+// workspaceStore holds the workspaces that haven't expired. Workspace IDs
+// are random and unguessable, so knowing one is what allows a client to use
+// the workspace.
+type workspaceStore struct {
+	mu         sync.Mutex
+	workspaces map[string]*storedWorkspace
+	// byClient counts the workspaces of each client that created them
+	byClient map[string]int
+}
+
+func newWorkspaceStore() *workspaceStore {
+	return &workspaceStore{workspaces: map[string]*storedWorkspace{}, byClient: map[string]int{}}
+}
+
+// create stores app as a new workspace of client, or returns an error if
+// there are already too many, overall or of client's, or app is too large.
+// Going over client's limit counts against it.
+func (s *workspaceStore) create(client string, app api.InitResponse) (Workspace, error) {
+	if err := checkAppSize(app); err != nil {
+		return Workspace{}, err
+	}
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return Workspace{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.workspaces) >= config.MaxWorkspaces {
+		return Workspace{}, errTooManyWorkspaces
+	}
+	if s.byClient[client] >= config.MaxWorkspacesPerClient {
+		abuse.record(client, abuseWorkspaceLimit)
+		return Workspace{}, errOwnWorkspaces
+	}
+
+	now := time.Now()
+	ws := &storedWorkspace{
+		id:       hex.EncodeToString(idBytes[:]),
+		client:   client,
+		created:  now,
+		lastUsed: now,
+		revisions: []storedRevision{{
+			Revision: Revision{Number: 1, Created: now, Message: "Create the app"},
+			app:      copyApp(app),
+		}},
+	}
+	s.workspaces[ws.id] = ws
+	s.byClient[client]++
+	p8sWorkspaces.Inc()
+	s.expireLater(ws, config.WorkspaceIdle)
+	return ws.workspace(), nil
+}
+
+// expireLater forgets ws after d if it hasn't been used since, and checks
+// again when it would next expire otherwise.
+func (s *workspaceStore) expireLater(ws *storedWorkspace, d time.Duration) {
+	time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.workspaces[ws.id] != ws {
+			return
+		}
+		if idle := time.Since(ws.lastUsed); idle < config.WorkspaceIdle {
+			s.expireLater(ws, config.WorkspaceIdle-idle)
+			return
+		}
+		s.forget(ws)
+	})
+}
+
+// forget removes ws from s. s.mu must be held.
+func (s *workspaceStore) forget(ws *storedWorkspace) {
+	delete(s.workspaces, ws.id)
+	if s.byClient[ws.client]--; s.byClient[ws.client] == 0 {
+		delete(s.byClient, ws.client)
+	}
+	p8sWorkspaces.Dec()
+}
+
+// use returns the workspace with the given ID, marking it as used. s.mu must
+// be held.
+func (s *workspaceStore) use(id string) (*storedWorkspace, error) {
+	ws, ok := s.workspaces[id]
+	if !ok {
+		return nil, errWorkspaceNotFound
+	}
+	ws.lastUsed = time.Now()
+	return ws, nil
+}
+
+// get returns the workspace with the given ID.
+func (s *workspaceStore) get(id string) (Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, err := s.use(id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	return ws.workspace(), nil
+}
+
+// revisions lists the revisions the workspace with the given ID keeps.
+func (s *workspaceStore) revisions(id string) ([]Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, err := s.use(id)
+	if err != nil {
+		return nil, err
+	}
+	list := []Revision{}
+	for _, rev := range ws.revisions {
+		list = append(list, rev.Revision)
+	}
+	return list, nil
+}
+
+// revision returns the app as it was at revision n of a workspace, the
+// current one if n is 0.
+func (s *workspaceStore) revision(id string, n int) (api.InitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, err := s.use(id)
+	if err != nil {
+		return api.InitResponse{}, err
+	}
+	rev, err := ws.find(n)
+	if err != nil {
+		return api.InitResponse{}, err
+	}
+	return copyApp(rev.app), nil
+}
+
+func (ws *storedWorkspace) find(n int) (storedRevision, error) {
+	if n == 0 {
+		return ws.current(), nil
+	}
+	for _, rev := range ws.revisions {
+		if rev.Number == n {
+			return rev, nil
+		}
+	}
+	return storedRevision{}, errRevisionNotFound
+}
+
+// update applies change to a copy of the current app of a workspace, and
+// keeps the result as a new revision if it changed anything.
+func (s *workspaceStore) update(id, message string, change func(app *api.InitResponse) error) (Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, err := s.use(id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	app := copyApp(ws.current().app)
+	if err := change(&app); err != nil {
+		return Workspace{}, err
+	}
+	if err := ws.commit(message, app); err != nil {
+		return Workspace{}, err
+	}
+	return ws.workspace(), nil
+}
+
+// rollback makes a new revision of a workspace with the app as it was at
+// revision n.
+func (s *workspaceStore) rollback(id string, n int) (Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, err := s.use(id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	rev, err := ws.find(n)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if err := ws.commit(fmt.Sprintf("Roll back to revision %d", n), copyApp(rev.app)); err != nil {
+		return Workspace{}, err
+	}
+	return ws.workspace(), nil
+}
+
+// commit keeps app as the new current revision of ws, unless it's the same
+// as the current one, dropping the oldest revisions beyond the limit.
+func (ws *storedWorkspace) commit(message string, app api.InitResponse) error {
+	current := ws.current()
+	if reflect.DeepEqual(app, current.app) {
+		return nil
+	}
+	if err := checkAppSize(app); err != nil {
+		return err
+	}
+
+	ws.revisions = append(ws.revisions, storedRevision{
+		Revision: Revision{Number: current.Number + 1, Created: time.Now(), Message: message},
+		app:      app,
+	})
+	if n := len(ws.revisions); n > config.MaxRevisions && config.MaxRevisions > 0 {
+		ws.revisions = append([]storedRevision(nil), ws.revisions[n-config.MaxRevisions:]...)
+	}
+	return nil
+}
+
+// checkAppSize returns errWorkspaceTooLarge if app is larger than the apps
+// requests can carry.
+func checkAppSize(app api.InitResponse) error {
+	bytes, err := json.Marshal(app)
+	if err != nil {
+		return err
+	}
+	if int64(len(bytes)) > config.MaxAppBytes {
+		return errWorkspaceTooLarge
+	}
+	return nil
+}
+
+// remove forgets the workspace with the given ID, returning its final state.
+func (s *workspaceStore) remove(id string) (Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws, err := s.use(id)
+	if err != nil {
+		return Workspace{}, err
+	}
+	s.forget(ws)
+	return ws.workspace(), nil
+}
+
+// fileParent returns the directory of app holding the file at path, and the
+// name of the file in it. With create set, missing directories are added.
+func fileParent(app *api.InitResponse, path string, create bool) (map[string]interface{}, string, error) {
+	segments := strings.Split(path, "/")
+	for _, name := range segments {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "\\\x00") {
+			return nil, "", badInputError(fmt.Sprintf("Invalid path %q", path))
+		}
+	}
+	if len(segments) < 2 {
+		return nil, "", badInputError(fmt.Sprintf("Invalid path %q - Expected app.yaml, or a file in components/ or environments/", path))
+	}
+
+	var dir map[string]interface{}
+	switch segments[0] {
+	case "components":
+		if app.Components == nil && create {
+			app.Components = map[string]interface{}{}
+		}
+		dir = app.Components
+	case "environments":
+		if app.Environments == nil && create {
+			app.Environments = map[string]interface{}{}
+		}
+		dir = app.Environments
+	default:
+		return nil, "", badInputError(fmt.Sprintf("Invalid path %q - Expected app.yaml, or a file in components/ or environments/", path))
+	}
+
+	for i, name := range segments[1 : len(segments)-1] {
+		next, ok := dir[name].(map[string]interface{})
+		if !ok {
+			if _, isFile := dir[name]; isFile {
+				return nil, "", badInputError(fmt.Sprintf("%s is a file", strings.Join(segments[:i+2], "/")))
+			}
+			if !create {
+				return nil, "", errNotFound
+			}
+			next = map[string]interface{}{}
+			dir[name] = next
+		}
+		dir = next
+	}
+	return dir, segments[len(segments)-1], nil
+}
+
+// readFile returns the contents of the file at path in app.
+func readFile(app api.InitResponse, path string) (string, error) {
+	if path == "app.yaml" {
+		return app.AppYAML, nil
+	}
+	dir, name, err := fileParent(&app, path, false)
+	if err != nil {
+		return "", err
+	}
+	switch entry := dir[name].(type) {
+	case string:
+		return entry, nil
+	case map[string]interface{}:
+		return "", badInputError(fmt.Sprintf("%s is a directory", path))
+	}
+	return "", errNotFound
+}
+
+// writeFile sets the contents of the file at path in app, adding it and its
+// directories if they're missing.
+func writeFile(app *api.InitResponse, path, contents string) error {
+	if path == "app.yaml" {
+		app.AppYAML = contents
+		return nil
+	}
+	dir, name, err := fileParent(app, path, true)
+	if err != nil {
+		return err
+	}
+	if _, ok := dir[name].(map[string]interface{}); ok {
+		return badInputError(fmt.Sprintf("%s is a directory", path))
+	}
+	dir[name] = contents
+	return nil
+}
+
+// deleteFile removes the file or directory at path from app.
+func deleteFile(app *api.InitResponse, path string) error {
+	if path == "app.yaml" {
+		return badInputError("app.yaml can't be deleted")
+	}
+	dir, name, err := fileParent(app, path, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := dir[name]; !ok {
+		return errNotFound
+	}
+	delete(dir, name)
+	return nil
+}
+
+// renameComponent renames a component of app, along with its parameters
+// globally and in each environment.
+func renameComponent(app *api.InitResponse, from, to string) error {
+	if err := validateName("component", to); err != nil {
+		return badInputError(err.Error())
+	}
+	if _, ok := app.Components[from+".jsonnet"].(string); !ok {
+		return errNotFound
+	}
+	if _, ok := app.Components[to+".jsonnet"]; ok {
+		return errComponentExists
+	}
+	app.Components[to+".jsonnet"] = app.Components[from+".jsonnet"]
+	delete(app.Components, from+".jsonnet")
+
+	dirs := []map[string]interface{}{app.Components}
+	for _, env := range app.Environments {
+		if env, ok := env.(map[string]interface{}); ok {
+			dirs = append(dirs, env)
+		}
+	}
+	for _, dir := range dirs {
+		code, ok := dir["params.libsonnet"].(string)
+		if !ok {
+			continue
+		}
+		code, err := renameParams(code, from, to)
+		if err == errComponentExists {
+			return err
+		} else if err != nil {
+			return badInputError(fmt.Sprintf("Could not edit params.libsonnet: %v", err))
+		}
+		dir["params.libsonnet"] = code
+	}
+	return nil
+}
+
+// appFiles maps the path of each file of app to its contents.
+func appFiles(app api.InitResponse) map[string]string {
+	files := map[string]string{"app.yaml": app.AppYAML}
+	var walk func(prefix string, tree map[string]interface{})
+	walk = func(prefix string, tree map[string]interface{}) {
+		for name, entry := range tree {
+			switch entry := entry.(type) {
+			case string:
+				files[prefix+name] = entry
+			case map[string]interface{}:
+				walk(prefix+name+"/", entry)
+			}
+		}
+	}
+	walk("components/", app.Components)
+	walk("environments/", app.Environments)
+	return files
+}
+
+// diffRevisions compares the files of two revisions of an app.
+func diffRevisions(from, to api.InitResponse) []FileDiff {
+	a, b := appFiles(from), appFiles(to)
+	var paths []string
+	for path := range a {
+		paths = append(paths, path)
+	}
+	for path := range b {
+		if _, ok := a[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	files := []FileDiff{}
+	for _, path := range paths {
+		contents, ok := a[path]
+		otherContents, otherOk := b[path]
+		if ok && otherOk && contents == otherContents {
+			continue
+		}
+		d := FileDiff{Path: path, Status: diffChanged}
+		fromName, toName := "a/"+path, "b/"+path
+		if !ok {
+			d.Status, fromName = diffAdded, "/dev/null"
+		}
+		if !otherOk {
+			d.Status, toName = diffRemoved, "/dev/null"
+		}
+		d.Diff = unifiedDiff(fromName, toName, contents, otherContents)
+		files = append(files, d)
+	}
+	return files
+}
+
+// workspaceStatus returns the HTTP status to send an error from a workspace
+// operation with.
+func workspaceStatus(err error) int {
+	if _, ok := err.(badInputError); ok {
+		return http.StatusBadRequest
+	}
+	switch err {
+	case errWorkspaceNotFound, errRevisionNotFound, errNotFound:
+		return http.StatusNotFound
+	case errComponentExists, errEnvExists:
+		return http.StatusConflict
+	case errTooManyWorkspaces, errOwnWorkspaces:
+		return http.StatusTooManyRequests
+	case errWorkspaceTooLarge:
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// workspaceID returns the ID of the workspace r is for.
+func workspaceID(r *http.Request) string {
+	return pathParam(r, "/workspaces/")
+}
+
+// createWorkspaceHandler scaffolds an app from an api.InitRequest, like
+// ksInit, and keeps it as a new workspace.
+func createWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.MaxContentLength)
+	defer r.Body.Close()
+
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	var req api.InitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	app, status, err := newApp(req)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	createWorkspace(w, clientID(r), app)
+}
+
+// createWorkspace keeps app as a new workspace of client, answering with it.
+func createWorkspace(w http.ResponseWriter, client string, app api.InitResponse) {
+	ws, err := workspaces.create(client, app)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	w.Header().Set("Location", apiPrefix+"/workspaces/"+ws.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ws)
+}
+
+// workspaceHandler answers with a workspace, including its whole app.
+func workspaceHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	ws, err := workspaces.get(workspaceID(r))
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, ws)
+}
+
+// deleteWorkspaceHandler forgets a workspace, answering with its final
+// state.
+func deleteWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	ws, err := workspaces.remove(workspaceID(r))
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, ws)
+}
+
+// fileHandler answers with the file of a workspace at the path in the query.
+func fileHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	app, err := workspaces.revision(workspaceID(r), 0)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	contents, err := readFile(app, path)
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No file %q in the workspace", path))
+		return
+	} else if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, WorkspaceFile{Path: path, Contents: contents})
+}
+
+// writeFileHandler sets the contents of the file of a workspace at the path
+// in the query to those of the WorkspaceFile in the body.
+func writeFileHandler(w http.ResponseWriter, r *http.Request) {
+	var file WorkspaceFile
+	if !readAppRequest(w, r, &file) {
+		return
+	}
+	path := r.URL.Query().Get("path")
+	ws, err := workspaces.update(workspaceID(r), "Write "+path, func(app *api.InitResponse) error {
+		return writeFile(app, path, file.Contents)
+	})
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, ws)
+}
+
+// deleteFileHandler removes the file or directory of a workspace at the path
+// in the query.
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	ws, err := workspaces.update(workspaceID(r), "Delete "+path, func(app *api.InitResponse) error {
+		return deleteFile(app, path)
+	})
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No file %q in the workspace", path))
+		return
+	} else if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, ws)
+}
+
+// renameHandler renames a component of a workspace.
+func renameHandler(w http.ResponseWriter, r *http.Request) {
+	var req RenameRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	message := fmt.Sprintf("Rename component %s to %s", req.Component, req.NewName)
+	ws, err := workspaces.update(workspaceID(r), message, func(app *api.InitResponse) error {
+		return renameComponent(app, req.Component, req.NewName)
+	})
+	if err == errNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No component %q in the workspace", req.Component))
+		return
+	} else if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, ws)
+}
+
+// showWorkspaceHandler renders the current app of a workspace, like ksShow.
+func showWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	var opts WorkspaceShowRequest
+	if !readAppRequest(w, r, &opts) {
+		return
+	}
+	app, err := workspaces.revision(workspaceID(r), 0)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+
+	req := api.ShowRequest{
+		AppName:      app.AppName,
+		Components:   app.Components,
+		Environments: app.Environments,
+		Environment:  opts.Environment,
+		K8sVersion:   opts.K8sVersion,
+		Format:       opts.Format,
+	}
+	if err := normalizeShowRequest(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	components, failed := renderEnvironment(r, req)
+	if failed != nil {
+		showEndpoint.write(w, r, *failed)
+		return
+	}
+	resp, err := showResponse(components, req.Format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// revisionsHandler lists the revisions a workspace keeps.
+func revisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	list, err := workspaces.revisions(workspaceID(r))
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, RevisionsResponse{Revisions: list})
+}
+
+// revisionDiffHandler compares the files of two revisions of a workspace:
+// the from and to revisions in the query, which default to the one before
+// the current one and the current one.
+func revisionDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if err := clientBlocked(clientID(r)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	id := workspaceID(r)
+	ws, err := workspaces.get(id)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+
+	resp := RevisionDiffResponse{From: ws.Revision - 1, To: ws.Revision}
+	if resp.From < 1 {
+		resp.From = 1
+	}
+	for param, n := range map[string]*int{"from": &resp.From, "to": &resp.To} {
+		if value := r.URL.Query().Get(param); value != "" {
+			if *n, err = strconv.Atoi(value); err != nil || *n < 1 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid %s revision %q", param, value))
+				return
+			}
+		}
+	}
+
+	from, err := workspaces.revision(id, resp.From)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	to, err := workspaces.revision(id, resp.To)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	resp.Files = diffRevisions(from, to)
+	var diffs []string
+	for _, f := range resp.Files {
+		diffs = append(diffs, f.Diff)
+	}
+	resp.Diff = strings.Join(diffs, "")
+	writeJSON(w, resp)
+}
+
+// rollbackHandler makes a new revision of a workspace with the app as it was
+// at an earlier revision.
+func rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	var req RollbackRequest
+	if !readAppRequest(w, r, &req) {
+		return
+	}
+	if req.Revision < 1 {
+		writeError(w, http.StatusBadRequest, errors.New("Missing the revision to roll back to"))
+		return
+	}
+	ws, err := workspaces.rollback(workspaceID(r), req.Revision)
+	if err != nil {
+		writeError(w, workspaceStatus(err), err)
+		return
+	}
+	writeJSON(w, ws)
+}